@@ -0,0 +1,430 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// A bytecode file (.gnbc) holds a compiled script:
+//
+//	magic     "GNBC"
+//	version   uint16, little endian
+//	checksum  uint32, little endian: CRC-32 (IEEE) of the payload
+//	payload   the name of the source file, then the script function
+//
+// A function is its name, arity, upvalue count, signature, code, the runs
+// of its position table and its constants; functions declared in it are
+// constants of their own. Integers are varints and strings are prefixed
+// with their length. Each run is stored as the difference with the one
+// before it.
+
+// BytecodeMagic starts every bytecode file.
+const BytecodeMagic = "GNBC"
+
+// BytecodeVersion is the version of the format written by WriteBytecode,
+// the only one ReadBytecode accepts.
+const BytecodeVersion = 2
+
+const headerSize = len(BytecodeMagic) + 2 + 4
+
+// Tags of the names and types that may be absent.
+const (
+	tagAbsent byte = iota
+	tagPresent
+)
+
+// IsBytecode reports whether data starts like a bytecode file.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(BytecodeMagic))
+}
+
+// WriteBytecode writes the script fn to w. The functions declared in fn
+// must come from the same source file.
+func WriteBytecode(w io.Writer, fn *GFunction) error {
+	var e encoder
+	e.string(fn.Chunk.Positions.Filename)
+	if err := e.function(fn); err != nil {
+		return err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, BytecodeMagic)
+	binary.LittleEndian.PutUint16(header[len(BytecodeMagic):], BytecodeVersion)
+	binary.LittleEndian.PutUint32(header[len(BytecodeMagic)+2:], crc32.ChecksumIEEE(e.buf.Bytes()))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+// ReadBytecode reads a script written by WriteBytecode.
+func ReadBytecode(r io.Reader) (*GFunction, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !IsBytecode(data) {
+		return nil, errors.New("not a GNBS bytecode file")
+	}
+	if len(data) < headerSize {
+		return nil, errors.New("truncated bytecode file")
+	}
+	if version := binary.LittleEndian.Uint16(data[len(BytecodeMagic):]); version != BytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d, want %d", version, BytecodeVersion)
+	}
+	payload := data[headerSize:]
+	if binary.LittleEndian.Uint32(data[len(BytecodeMagic)+2:]) != crc32.ChecksumIEEE(payload) {
+		return nil, errors.New("corrupted bytecode file: checksum mismatch")
+	}
+
+	d := decoder{r: bytes.NewReader(payload)}
+	d.filename = d.string()
+	fn := d.function()
+	if d.err == nil && d.r.Len() > 0 {
+		d.err = errors.New("trailing data")
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("corrupted bytecode file: %v", d.err)
+	}
+	return fn, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], x)])
+}
+
+func (e *encoder) varint(x int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutVarint(b[:], x)])
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) function(fn *GFunction) error {
+	if fn.Name == nil {
+		e.buf.WriteByte(tagAbsent)
+	} else {
+		e.buf.WriteByte(tagPresent)
+		e.string(fn.Name.String)
+	}
+	e.uvarint(uint64(fn.Arity))
+	e.uvarint(uint64(fn.UpvalueCount))
+	e.types(fn.ParamTypes)
+	e.typ(fn.ReturnType)
+
+	e.uvarint(uint64(len(fn.Chunk.Code)))
+	e.buf.Write(fn.Chunk.Code)
+	e.positions(&fn.Chunk.Positions)
+
+	e.uvarint(uint64(len(fn.Chunk.Values)))
+	for _, value := range fn.Chunk.Values {
+		if err := e.value(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) positions(t *PosTable) {
+	e.uvarint(uint64(len(t.runs)))
+	var prev posRun
+	for _, run := range t.runs {
+		e.uvarint(uint64(run.start - prev.start))
+		e.varint(int64(run.line - prev.line))
+		e.varint(int64(run.offset - prev.offset))
+		e.uvarint(uint64(run.column))
+		prev = run
+	}
+}
+
+func (e *encoder) value(v Value) error {
+	e.buf.WriteByte(byte(v.Type))
+	switch v.Type {
+	case TypeBool:
+		if v.Bool() {
+			e.buf.WriteByte(1)
+		} else {
+			e.buf.WriteByte(0)
+		}
+	case TypeInteger:
+		e.varint(v.Integer())
+	case TypeFloat:
+		e.uvarint(math.Float64bits(v.Float()))
+	case TypeString:
+		e.string(v.String())
+	case TypeNull:
+	case TypeFunction:
+		return e.function(v.Value.(*GFunction))
+	default:
+		return fmt.Errorf("cannot write a constant of type %d", v.Type)
+	}
+	return nil
+}
+
+func (e *encoder) types(ts []*Type) {
+	e.uvarint(uint64(len(ts)))
+	for _, t := range ts {
+		e.typ(t)
+	}
+}
+
+// typ writes t as the compiler left it for the VM: a function type with
+// its signature, if any, and a class type with the names of the class and
+// its superclasses.
+func (e *encoder) typ(t *Type) {
+	if t == nil {
+		e.buf.WriteByte(tagAbsent)
+		return
+	}
+	e.buf.WriteByte(tagPresent)
+	e.buf.WriteByte(byte(t.Kind))
+	switch t.Kind {
+	case TypeFunction:
+		e.types(t.Params)
+		e.typ(t.Return)
+	case TypeClass, TypeInstance:
+		e.classType(t.Class)
+	}
+}
+
+func (e *encoder) classType(c *ClassType) {
+	if c == nil {
+		e.buf.WriteByte(tagAbsent)
+		return
+	}
+	e.buf.WriteByte(tagPresent)
+	e.string(c.Name)
+	e.classType(c.Super)
+}
+
+// decoder reads a payload. The first error is kept in err and stops the
+// reading: the methods return zero values from then on.
+type decoder struct {
+	r        *bytes.Reader
+	filename string
+	err      error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+}
+
+// check fails with err, an error of the reader.
+func (d *decoder) check(err error) {
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		d.fail("unexpected end of data")
+	default:
+		d.fail("bad varint: %v", err)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	d.check(err)
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(d.r)
+	d.check(err)
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(d.r)
+	d.check(err)
+	return x
+}
+
+func (d *decoder) int() int {
+	x := d.uvarint()
+	if x > math.MaxInt32 {
+		d.fail("number out of range: %d", x)
+		return 0
+	}
+	return int(x)
+}
+
+// length reads the length of a list, which can't be longer than what is
+// left to read.
+func (d *decoder) length() int {
+	n := d.int()
+	if n > d.r.Len() {
+		d.fail("length %d beyond the end of data", n)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	b := make([]byte, d.length())
+	_, err := io.ReadFull(d.r, b)
+	d.check(err)
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) present() bool {
+	switch tag := d.byte(); tag {
+	case tagAbsent:
+		return false
+	case tagPresent:
+		return true
+	default:
+		d.fail("bad tag %d", tag)
+		return false
+	}
+}
+
+func (d *decoder) function() *GFunction {
+	fn := NewGFunction()
+	if d.present() {
+		fn.Name = NewGString(d.string())
+	}
+	fn.Arity = d.int()
+	fn.UpvalueCount = d.int()
+	fn.ParamTypes = d.types()
+	fn.ReturnType = d.typ()
+
+	fn.Chunk.Code = d.bytes()
+	fn.Chunk.Positions = d.positions(len(fn.Chunk.Code))
+
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		fn.Chunk.Values = append(fn.Chunk.Values, d.value())
+	}
+	return fn
+}
+
+// positions reads the position table of size bytes of code. The runs must
+// start at 0 and go up, within the code.
+func (d *decoder) positions(size int) PosTable {
+	t := PosTable{Filename: d.filename}
+	n := d.length()
+	var prev posRun
+	for i := 0; i < n && d.err == nil; i++ {
+		run := posRun{
+			start:  prev.start + d.int(),
+			line:   prev.line + int(d.varint()),
+			offset: prev.offset + int(d.varint()),
+			column: d.int(),
+		}
+		if (i == 0 && run.start != 0) || (i > 0 && run.start <= prev.start) || run.start >= size {
+			d.fail("bad position table")
+			break
+		}
+		t.runs = append(t.runs, run)
+		prev = run
+	}
+	return t
+}
+
+func (d *decoder) value() Value {
+	v := Value{Type: ValueType(d.byte())}
+	switch v.Type {
+	case TypeBool:
+		v.Value = d.byte() != 0
+	case TypeInteger:
+		v.Value = d.varint()
+	case TypeFloat:
+		v.Value = math.Float64frombits(d.uvarint())
+	case TypeString:
+		v.Value = NewGString(d.string())
+	case TypeNull:
+	case TypeFunction:
+		v.Value = d.function()
+	default:
+		d.fail("bad constant type %d", v.Type)
+	}
+	return v
+}
+
+func (d *decoder) types() []*Type {
+	n := d.length()
+	if n == 0 {
+		return nil
+	}
+	ts := make([]*Type, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		if ts[i] = d.typ(); ts[i] == nil {
+			d.fail("missing parameter type")
+		}
+	}
+	return ts
+}
+
+// basicTypes are the shared types that typ returns for the kinds without
+// details.
+var basicTypes = map[ValueType]*Type{
+	TypeAny:     AnyType,
+	TypeBool:    BoolType,
+	TypeInteger: IntType,
+	TypeFloat:   FloatType,
+	TypeString:  StringType,
+	TypeNull:    NullType,
+}
+
+func (d *decoder) typ() *Type {
+	if !d.present() {
+		return nil
+	}
+	kind := ValueType(d.byte())
+	if t, ok := basicTypes[kind]; ok {
+		return t
+	}
+	switch kind {
+	case TypeFunction:
+		params := d.types()
+		return NewFunctionType(params, d.typ())
+	case TypeClass, TypeInstance:
+		class := d.classType()
+		if class == nil {
+			d.fail("class type without a class")
+		}
+		return &Type{Kind: kind, Class: class}
+	case TypeClosure, TypeNative, TypeBoundMethod:
+		return &Type{Kind: kind}
+	}
+	d.fail("bad type kind %d", kind)
+	return AnyType
+}
+
+func (d *decoder) classType() *ClassType {
+	if !d.present() || d.err != nil {
+		return nil
+	}
+	c := &ClassType{Name: d.string()}
+	c.Super = d.classType()
+	return c
+}
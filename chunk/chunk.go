@@ -2,13 +2,15 @@ package chunk
 
 import (
 	"GNBS/token"
-	"encoding/binary"
+	"sort"
 )
 
 type Chunk struct {
 	Code   []byte
 	Values []Value
-	Pos    []token.Position
+	// Positions maps each byte of Code to where it comes from in the
+	// source.
+	Positions PosTable
 }
 
 func NewChunk() *Chunk {
@@ -17,14 +19,85 @@ func NewChunk() *Chunk {
 
 func (c *Chunk) WriteChunk(by byte, position token.Position) {
 	c.Code = append(c.Code, by)
-	c.Pos = append(c.Pos, position)
+	c.Positions.Add(len(c.Code)-1, position)
 }
 
-func (c *Chunk) AddConstant(value Value) byte {
+// Truncate removes the code from offset size on.
+func (c *Chunk) Truncate(size int) {
+	c.Code = c.Code[:size]
+	c.Positions.truncate(size)
+}
+
+// Position returns the position in the source of the byte of code at
+// offset.
+func (c *Chunk) Position(offset int) token.Position {
+	return c.Positions.Lookup(offset)
+}
+
+// AddConstant adds value to the constants of the chunk and returns its
+// index.
+func (c *Chunk) AddConstant(value Value) int {
 	c.Values = append(c.Values, value)
-	length := len(c.Values) - 1
+	return len(c.Values) - 1
+}
+
+// PosTable maps offsets in code to positions in a source file. The bytes
+// that follow each other at the same position, such as an instruction and
+// its operands, share a single run; a lookup is a binary search among the
+// runs.
+type PosTable struct {
+	// Filename is the file of all the positions.
+	Filename string
+	runs     []posRun
+}
+
+// posRun is the position of the bytes of code from start up to the start
+// of the next run.
+type posRun struct {
+	start  int
+	offset int
+	line   int
+	column int
+}
+
+// Add records that the code at offset, past the offsets already added,
+// comes from pos. The filename of pos is only kept from the first call.
+func (t *PosTable) Add(offset int, pos token.Position) {
+	if len(t.runs) == 0 {
+		t.Filename = pos.Filename
+	} else if last := t.runs[len(t.runs)-1]; last.offset == pos.Offset && last.line == pos.Line && last.column == pos.Column {
+		return
+	}
+	t.runs = append(t.runs, posRun{
+		start:  offset,
+		offset: pos.Offset,
+		line:   pos.Line,
+		column: pos.Column,
+	})
+}
+
+// truncate removes the runs of the code from offset size on.
+func (t *PosTable) truncate(size int) {
+	i := sort.Search(len(t.runs), func(i int) bool {
+		return t.runs[i].start >= size
+	})
+	t.runs = t.runs[:i]
+}
 
-	bs := make([]byte, 4)
-	binary.LittleEndian.PutUint16(bs, uint16(length))
-	return bs[0]
+// Lookup returns the position of the code at offset, or the zero Position
+// if offset comes before the first run.
+func (t *PosTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(t.runs), func(i int) bool {
+		return t.runs[i].start > offset
+	}) - 1
+	if i < 0 {
+		return token.Position{}
+	}
+	run := t.runs[i]
+	return token.Position{
+		Filename: t.Filename,
+		Offset:   run.offset,
+		Line:     run.line,
+		Column:   run.column,
+	}
 }
@@ -0,0 +1,48 @@
+package chunk
+
+import (
+	"GNBS/token"
+	"testing"
+)
+
+func TestPosTable(t *testing.T) {
+	positions := []token.Position{
+		{Filename: "a.gnbs", Offset: 0, Line: 1, Column: 1},
+		{Filename: "a.gnbs", Offset: 0, Line: 1, Column: 1},
+		{Filename: "a.gnbs", Offset: 4, Line: 1, Column: 5},
+		{Filename: "a.gnbs", Offset: 4, Line: 1, Column: 5},
+		{Filename: "a.gnbs", Offset: 4, Line: 1, Column: 5},
+		{Filename: "a.gnbs", Offset: 9, Line: 2, Column: 1},
+		{Filename: "a.gnbs", Offset: 4, Line: 1, Column: 5},
+	}
+	c := NewChunk()
+	for _, pos := range positions {
+		c.WriteChunk(0, pos)
+	}
+
+	if n := len(c.Positions.runs); n != 4 {
+		t.Errorf("got %d runs, want 4", n)
+	}
+	for offset, want := range positions {
+		if got := c.Position(offset); got != want {
+			t.Errorf("offset %d: got %+v, want %+v", offset, got, want)
+		}
+	}
+	if got := c.Position(-1); got != (token.Position{}) {
+		t.Errorf("offset -1: got %+v", got)
+	}
+
+	c.Truncate(3)
+	if len(c.Code) != 3 || len(c.Positions.runs) != 2 {
+		t.Errorf("truncated to %d bytes and %d runs, want 3 and 2", len(c.Code), len(c.Positions.runs))
+	}
+	c.WriteChunk(0, positions[5])
+	if got := c.Position(3); got != positions[5] {
+		t.Errorf("offset 3 after truncating: got %+v, want %+v", got, positions[5])
+	}
+
+	var empty PosTable
+	if got := empty.Lookup(0); got != (token.Position{}) {
+		t.Errorf("empty table: got %+v", got)
+	}
+}
@@ -3,10 +3,12 @@ package chunk
 const TableMaxLoad = 0.75
 
 type Table struct {
-	Entries []*Entry
+	Entries []Entry
 	Count   int
 }
 
+// Entry is a slot of the table. A slot without a key is empty when its
+// value is null and a tombstone left by TableDelete otherwise.
 type Entry struct {
 	Key   *GString
 	Value Value
@@ -16,14 +18,14 @@ func NewTable() *Table {
 	return &Table{nil, 0}
 }
 
-func FindEntry(entries []*Entry, key *GString) *Entry {
-	capacity := uint32(cap(entries))
+func FindEntry(entries []Entry, key *GString) *Entry {
+	capacity := uint32(len(entries))
 	index := key.Hash % capacity
 
 	var tombstone *Entry = nil
 
 	for {
-		if entry := entries[index]; entry.Key == nil {
+		if entry := &entries[index]; entry.Key == nil {
 			if entry.Value.Type == TypeNull {
 				if tombstone == nil {
 					return entry
@@ -35,7 +37,7 @@ func FindEntry(entries []*Entry, key *GString) *Entry {
 					tombstone = entry
 				}
 			}
-		} else if entry.Key == key {
+		} else if entry.Key == key || entry.Key.Hash == key.Hash && entry.Key.String == key.String {
 			return entry
 		}
 
@@ -44,24 +46,24 @@ func FindEntry(entries []*Entry, key *GString) *Entry {
 }
 
 func (t *Table) AdjustCapacity() {
-	capacity := cap(t.Entries)
+	capacity := len(t.Entries)
 	if capacity < 8 {
 		capacity = 8
 	} else {
 		capacity *= 2
 	}
 
-	entries := make([]*Entry, capacity)
+	entries := make([]Entry, capacity)
 
 	for i := 0; i < capacity; i++ {
 		entries[i].Key = nil
-		entries[i].Value = Value{}
+		entries[i].Value = Value{Type: TypeNull}
 	}
 
 	t.Count = 0
-	for i := 0; i < cap(t.Entries); i++ {
-		entry := t.Entries[i]
-		if entry == nil {
+	for i := 0; i < len(t.Entries); i++ {
+		entry := &t.Entries[i]
+		if entry.Key == nil {
 			continue
 		}
 		dest := FindEntry(entries, entry.Key)
@@ -73,7 +75,7 @@ func (t *Table) AdjustCapacity() {
 }
 
 func (t *Table) TableSet(key *GString, value Value) bool {
-	if float64(t.Count+1) > float64(cap(t.Entries))*TableMaxLoad {
+	if float64(t.Count+1) > float64(len(t.Entries))*TableMaxLoad {
 		t.AdjustCapacity()
 	}
 
@@ -113,15 +115,15 @@ func (t *Table) TableDelete(key *GString) bool {
 	entry.Key = nil
 	entry.Value = Value{
 		Type:  TypeBool,
-		Value: false,
+		Value: true,
 	}
 
 	return true
 }
 
 func TableAddAll(from, to *Table) {
-	for i := 0; i < cap(from.Entries); i++ {
-		if entry := from.Entries[i]; entry.Key != nil {
+	for i := 0; i < len(from.Entries); i++ {
+		if entry := &from.Entries[i]; entry.Key != nil {
 			to.TableSet(entry.Key, entry.Value)
 		}
 	}
@@ -131,10 +133,10 @@ func TableFindString(table *Table, message string, hash uint32) *GString {
 		return nil
 	}
 
-	index := hash % uint32(cap(table.Entries))
+	index := hash % uint32(len(table.Entries))
 
 	for {
-		entry := table.Entries[index]
+		entry := &table.Entries[index]
 		if entry.Key == nil {
 			if entry.Value.Type == TypeNull {
 				return nil
@@ -143,6 +145,6 @@ func TableFindString(table *Table, message string, hash uint32) *GString {
 			entry.Key.Hash == hash && entry.Key.String == message {
 			return entry.Key
 		}
-		index = (index + 1) % uint32(cap(table.Entries))
+		index = (index + 1) % uint32(len(table.Entries))
 	}
 }
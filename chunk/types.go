@@ -0,0 +1,164 @@
+package chunk
+
+import "strings"
+
+// Type is the static type of a GNBS expression, as known by the compiler.
+// Params and Return are only set for functions, Class for classes and
+// their instances.
+type Type struct {
+	Kind   ValueType
+	Params []*Type
+	Return *Type
+	Class  *ClassType
+}
+
+// ClassType is what the compiler knows about a class: the types of its
+// fields and the signatures of its methods, inherited ones included. At run
+// time only the names of the class and its superclasses are known.
+type ClassType struct {
+	Name    string
+	Super   *ClassType
+	Fields  map[string]*Type
+	Methods map[string]*Type
+}
+
+var (
+	AnyType    = &Type{Kind: TypeAny}
+	BoolType   = &Type{Kind: TypeBool}
+	IntType    = &Type{Kind: TypeInteger}
+	FloatType  = &Type{Kind: TypeFloat}
+	StringType = &Type{Kind: TypeString}
+	NullType   = &Type{Kind: TypeNull}
+)
+
+func NewFunctionType(params []*Type, ret *Type) *Type {
+	return &Type{Kind: TypeFunction, Params: params, Return: ret}
+}
+
+func NewClassType(name string) *ClassType {
+	return &ClassType{
+		Name:    name,
+		Fields:  make(map[string]*Type),
+		Methods: make(map[string]*Type),
+	}
+}
+
+// Property returns the type of the field or method called name.
+func (c *ClassType) Property(name string) (*Type, bool) {
+	if t, ok := c.Fields[name]; ok {
+		return t, true
+	}
+	t, ok := c.Methods[name]
+	return t, ok
+}
+
+// Inherit gives c the fields and methods of super.
+func (c *ClassType) Inherit(super *ClassType) {
+	c.Super = super
+	for name, t := range super.Fields {
+		c.Fields[name] = t
+	}
+	for name, t := range super.Methods {
+		c.Methods[name] = t
+	}
+}
+
+// inherits reports whether c or one of its superclasses is the same class
+// as u according to same.
+func (c *ClassType) inherits(u *ClassType, same func(a, b *ClassType) bool) bool {
+	for ; c != nil; c = c.Super {
+		if same(c, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// InstanceType is the type of the values built by calling the class.
+func (c *ClassType) InstanceType() *Type {
+	return &Type{Kind: TypeInstance, Class: c}
+}
+
+func (t *Type) IsAny() bool { return t.Kind == TypeAny }
+
+func (t *Type) IsNumeric() bool {
+	return t.Kind == TypeInteger || t.Kind == TypeFloat
+}
+
+// AssignableTo reports whether a value of type t can be stored where a u is
+// expected. A type that is not known statically is assignable both ways,
+// and nul is the zero value of every class.
+func (t *Type) AssignableTo(u *Type) bool {
+	return t.assignableTo(u, func(a, b *ClassType) bool { return a == b })
+}
+
+// AssignableByName is AssignableTo for the types known at run time, where
+// a class is only known by its name and those of its superclasses: two
+// classes declared with the same name are the same.
+func (t *Type) AssignableByName(u *Type) bool {
+	return t.assignableTo(u, func(a, b *ClassType) bool { return a.Name == b.Name })
+}
+
+func (t *Type) assignableTo(u *Type, same func(a, b *ClassType) bool) bool {
+	if t.IsAny() || u.IsAny() {
+		return true
+	}
+	if t.Kind == TypeNull && u.Kind == TypeInstance {
+		return true
+	}
+	if t.Kind != u.Kind {
+		return false
+	}
+	if t.Kind == TypeClass {
+		return same(t.Class, u.Class)
+	}
+	if t.Kind == TypeInstance {
+		return t.Class.inherits(u.Class, same)
+	}
+	if t.Kind != TypeFunction || t.Return == nil || u.Return == nil {
+		return true
+	}
+
+	if len(t.Params) != len(u.Params) || !t.Return.assignableTo(u.Return, same) {
+		return false
+	}
+	for i := range t.Params {
+		if !u.Params[i].assignableTo(t.Params[i], same) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Type) String() string {
+	switch t.Kind {
+	case TypeAny:
+		return "?"
+	case TypeBool:
+		return "Bool"
+	case TypeInteger:
+		return "Ent"
+	case TypeFloat:
+		return "Flot"
+	case TypeString:
+		return "Cha"
+	case TypeNull:
+		return "nul"
+	case TypeNative:
+		return "native"
+	case TypeClass:
+		return "classe " + t.Class.Name
+	case TypeInstance:
+		return t.Class.Name
+	case TypeFunction:
+		if t.Return == nil {
+			return "fonction"
+		}
+		params := make([]string, len(t.Params))
+		for i, param := range t.Params {
+			params[i] = param.String()
+		}
+		return "fonction(" + strings.Join(params, ", ") + ") -> " + t.Return.String()
+	}
+	return "?"
+}
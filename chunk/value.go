@@ -1,6 +1,10 @@
 package chunk
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
 
 type ValueType int
 
@@ -10,8 +14,16 @@ const (
 	TypeFloat
 	TypeString
 	TypeFunction
+	TypeClosure
 	TypeNative
+	TypeClass
+	TypeInstance
+	TypeBoundMethod
 	TypeNull
+
+	// TypeAny is never the type of a value; the compiler uses it for the
+	// expressions whose type is only known at run time.
+	TypeAny
 )
 
 type Value struct {
@@ -25,14 +37,63 @@ type GString struct {
 }
 
 type GFunction struct {
-	Arity int
-	Chunk Chunk
-	Name  *GString
+	Arity        int
+	UpvalueCount int
+	Chunk        Chunk
+	Name         *GString
+
+	// ParamTypes and ReturnType are the declared signature, AnyType where
+	// nothing was declared.
+	ParamTypes []*Type
+	ReturnType *Type
+}
+
+// GClosure is a function together with the variables it captured from
+// the functions that enclose it.
+type GClosure struct {
+	Function *GFunction
+	Upvalues []*GUpvalue
+}
+
+// GUpvalue is a captured variable. While it is open, Location points to
+// the stack slot Slot; once the variable goes out of scope its value is
+// moved to Closed and Location points there.
+type GUpvalue struct {
+	Location *Value
+	Closed   Value
+	Slot     int
+	Next     *GUpvalue
 }
 
-type NativeFn func(argCount byte, args []Value) Value
+// NativeFn is the Go side of a native function. It is called with as many
+// arguments as there are ParamTypes, already checked against them.
+type NativeFn func(args []Value) (Value, error)
 type GNative struct {
-	Function NativeFn
+	Name       *GString
+	ParamTypes []*Type
+	ReturnType *Type
+	Function   NativeFn
+}
+
+// GClass holds the zero value of every declared field, which each new
+// instance starts with, and the methods.
+type GClass struct {
+	Name       *GString
+	Superclass *GClass
+	Fields     *Table
+	Methods    *Table
+}
+
+type GInstance struct {
+	Class  *GClass
+	Fields *Table
+}
+
+// GBoundMethod is a method read from an instance, which is called with
+// that instance as ceci.
+type GBoundMethod struct {
+	Receiver Value
+	Method   *GClosure
 }
 
 func NewGString(value string) *GString {
@@ -44,14 +105,45 @@ func NewGString(value string) *GString {
 
 func NewGFunction() *GFunction {
 	return &GFunction{
-		Arity: 0,
-		Chunk: *NewChunk(),
-		Name:  nil,
+		Arity:      0,
+		Chunk:      *NewChunk(),
+		Name:       nil,
+		ReturnType: AnyType,
 	}
 }
 
-func NewGNative(function NativeFn) *GNative {
-	return &GNative{Function: function}
+func NewGNative(name string, params []*Type, ret *Type, function NativeFn) *GNative {
+	return &GNative{
+		Name:       NewGString(name),
+		ParamTypes: params,
+		ReturnType: ret,
+		Function:   function,
+	}
+}
+
+func NewGClass(name *GString) *GClass {
+	return &GClass{Name: name, Fields: NewTable(), Methods: NewTable()}
+}
+
+func NewGInstance(class *GClass) *GInstance {
+	instance := &GInstance{Class: class, Fields: NewTable()}
+	TableAddAll(class.Fields, instance.Fields)
+	return instance
+}
+
+func NewGClosure(function *GFunction) *GClosure {
+	return &GClosure{
+		Function: function,
+		Upvalues: make([]*GUpvalue, function.UpvalueCount),
+	}
+}
+
+func NewGUpvalue(slot *Value, index int) *GUpvalue {
+	return &GUpvalue{Location: slot, Slot: index}
+}
+
+func NewGBoundMethod(receiver Value, method *GClosure) *GBoundMethod {
+	return &GBoundMethod{Receiver: receiver, Method: method}
 }
 
 func (v *Value) Bool() bool {
@@ -59,8 +151,8 @@ func (v *Value) Bool() bool {
 	return value
 }
 
-func (v *Value) Integer() int {
-	value, _ := v.Value.(int)
+func (v *Value) Integer() int64 {
+	value, _ := v.Value.(int64)
 	return value
 }
 
@@ -75,43 +167,126 @@ func (v *Value) String() string {
 }
 
 func (v *Value) FunctionName() string {
-	value, _ := v.Value.(*GFunction)
-	return value.Name.String
+	var fn *GFunction
+	switch value := v.Value.(type) {
+	case *GFunction:
+		fn = value
+	case *GClosure:
+		fn = value.Function
+	case *GBoundMethod:
+		fn = value.Method.Function
+	case *GNative:
+		return value.Name.String
+	}
+	if fn == nil || fn.Name == nil {
+		return ""
+	}
+	return fn.Name.String
+}
+
+// StaticType returns the static type of the value, with the signature of
+// functions.
+func (v *Value) StaticType() *Type {
+	switch v.Type {
+	case TypeBool:
+		return BoolType
+	case TypeInteger:
+		return IntType
+	case TypeFloat:
+		return FloatType
+	case TypeString:
+		return StringType
+	case TypeNull:
+		return NullType
+	case TypeFunction:
+		fn, _ := v.Value.(*GFunction)
+		return NewFunctionType(fn.ParamTypes, fn.ReturnType)
+	case TypeClosure:
+		closure, _ := v.Value.(*GClosure)
+		return NewFunctionType(closure.Function.ParamTypes, closure.Function.ReturnType)
+	case TypeBoundMethod:
+		bound, _ := v.Value.(*GBoundMethod)
+		return NewFunctionType(bound.Method.Function.ParamTypes, bound.Method.Function.ReturnType)
+	case TypeNative:
+		native, _ := v.Value.(*GNative)
+		return NewFunctionType(native.ParamTypes, native.ReturnType)
+	case TypeClass:
+		class, _ := v.Value.(*GClass)
+		return &Type{Kind: TypeClass, Class: class.staticType()}
+	case TypeInstance:
+		instance, _ := v.Value.(*GInstance)
+		return instance.Class.staticType().InstanceType()
+	}
+	return AnyType
+}
+
+// staticType is the class as far as it is known at run time: its name and
+// the names of its superclasses.
+func (c *GClass) staticType() *ClassType {
+	if c == nil {
+		return nil
+	}
+	return &ClassType{Name: c.Name.String, Super: c.Superclass.staticType()}
+}
+
+func ValuesEqual(a, b Value) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Type == TypeString {
+		return a.String() == b.String()
+	}
+	return a.Value == b.Value
 }
 
 func PrintValue(value Value) {
+	FprintValue(os.Stdout, value)
+}
+
+func FprintValue(w io.Writer, value Value) {
 	switch value.Type {
 	case TypeBool:
 		if value.Bool() {
-			fmt.Println("true")
+			fmt.Fprint(w, "vrai")
 		} else {
-			fmt.Println("false")
+			fmt.Fprint(w, "faux")
 		}
 		break
 	case TypeNull:
-		fmt.Println("null")
+		fmt.Fprint(w, "nul")
 		break
 	case TypeInteger:
-		fmt.Printf("%g", value.Integer())
+		fmt.Fprintf(w, "%d", value.Integer())
 		break
 	case TypeFloat:
-		fmt.Printf("%g", value.Float())
+		fmt.Fprintf(w, "%g", value.Float())
 		break
 	case TypeString:
-		fmt.Print(value.String())
+		fmt.Fprint(w, value.String())
 		break
-	case TypeFunction:
+	case TypeFunction, TypeClosure:
 		if name := value.FunctionName(); name == "" {
-			fmt.Printf("<script>")
+			fmt.Fprintf(w, "<script>")
 		} else {
-			fmt.Printf("<fn %s>", name)
+			fmt.Fprintf(w, "<fn %s>", name)
 		}
 		break
 	case TypeNative:
-		fmt.Printf("<native fn>")
+		fmt.Fprintf(w, "<native fn %s>", value.FunctionName())
+		break
+	case TypeClass:
+		class, _ := value.Value.(*GClass)
+		fmt.Fprint(w, class.Name.String)
+		break
+	case TypeInstance:
+		instance, _ := value.Value.(*GInstance)
+		fmt.Fprintf(w, "<instance de %s>", instance.Class.Name.String)
+		break
+	case TypeBoundMethod:
+		fmt.Fprintf(w, "<fn %s>", value.FunctionName())
 		break
 	default:
-		fmt.Printf("%g", value.Value)
+		fmt.Fprintf(w, "%g", value.Value)
 		break
 	}
 
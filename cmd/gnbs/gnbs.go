@@ -1,12 +1,21 @@
 package main
 
 import (
-	"GNBS/old"
+	"GNBS/chunk"
+	"GNBS/compiler"
+	"GNBS/scanner"
+	"GNBS/token"
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func main() {
@@ -16,17 +25,38 @@ func main() {
 	}
 }
 
+// Output formats of the errors.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
 func rootCommand() *cobra.Command {
+	var localeName, format string
+	var opts compiler.Options
+
 	cmd := &cobra.Command{
 		Use:   "gnbs",
 		Short: "Compiler",
 		Long:  "",
+		Args:  cobra.ArbitraryArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			locale, err := getLocale(localeName)
+			if err != nil {
+				return err
+			}
+			opts.Locale = locale
+			if format != formatText && format != formatJSON {
+				return fmt.Errorf("unknown format %q", format)
+			}
+			return nil
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			vm := old.NewVM()
 			if len(args) == 0 {
-				repl(vm)
+				repl(compiler.NewVM(opts), format)
 			} else if len(args) == 1 {
-				runFile(args[0], vm)
+				opts.Filename = args[0]
+				runFile(compiler.NewVM(opts), args[0], format)
 			} else {
 				os.Exit(64)
 				return
@@ -34,32 +64,198 @@ func rootCommand() *cobra.Command {
 
 		},
 	}
+	cmd.PersistentFlags().StringVar(&localeName, "locale", token.French.Name, "spelling of the reserved words (fr or en)")
+	cmd.PersistentFlags().StringVar(&format, "format", formatText, "format of the errors (text or json)")
+	cmd.PersistentFlags().BoolVar(&opts.NoOptimize, "no-opt", false, "don't compute constant expressions at compile time")
+
+	cmd.AddCommand(translateCommand(&localeName))
+	cmd.AddCommand(checkCommand(&opts, &format))
+	cmd.AddCommand(buildCommand(&opts, &format))
+	return cmd
+}
+
+func buildCommand(opts *compiler.Options, format *string) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "build [file]",
+		Short: "Compile a source file to a bytecode file",
+		Long: "Compile a source file to a bytecode file, which gnbs runs without\n" +
+			"compiling it again. The output defaults to the name of the source\n" +
+			"file with the .gnbc extension.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileOpts := *opts
+			fileOpts.Filename = args[0]
+			source := readFile(args[0])
+
+			fn, diagnostics := compiler.New(fileOpts).Compile(source)
+			if len(diagnostics) > 0 {
+				printError(os.Stderr, diagnostics, source, *format)
+			}
+			if fn == nil {
+				os.Exit(65)
+			}
+
+			if output == "" {
+				output = strings.TrimSuffix(args[0], filepath.Ext(args[0])) + ".gnbc"
+			}
+			var buffer bytes.Buffer
+			if err := chunk.WriteBytecode(&buffer, fn); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(output, buffer.Bytes(), 0644)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the bytecode to this file")
+	return cmd
+}
+
+func checkCommand(opts *compiler.Options, format *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "check [files]",
+		Short: "Compile source files without running them and report their errors",
+		Long: "Compile source files without running them and report their errors.\n" +
+			"With --format=json, each error is written to stdout as a JSON object\n" +
+			"on a line of its own.",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			failed := false
+			for _, path := range args {
+				fileOpts := *opts
+				fileOpts.Filename = path
+				source := readFile(path)
+
+				fn, diagnostics := compiler.New(fileOpts).Compile(source)
+				if len(diagnostics) > 0 {
+					printError(os.Stdout, diagnostics, source, *format)
+				}
+				if fn == nil {
+					failed = true
+				}
+			}
+			if failed {
+				os.Exit(65)
+			}
+		},
+	}
+}
+
+func translateCommand(from *string) *cobra.Command {
+	var to, output string
+
+	cmd := &cobra.Command{
+		Use:   "translate [file]",
+		Short: "Rewrite a source file with the reserved words of another locale",
+		Long: "Rewrite a source file with the reserved words of another locale.\n" +
+			"The file is read with --locale or its //gnbs:locale pragma; comments,\n" +
+			"literals and layout are kept as they are.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fromLocale, err := getLocale(*from)
+			if err != nil {
+				return err
+			}
+			toLocale, err := getLocale(to)
+			if err != nil {
+				return err
+			}
+
+			translated, err := scanner.Translate(readFile(args[0]), fromLocale, toLocale)
+			if err != nil {
+				return fmt.Errorf("%s:%v", args[0], err)
+			}
+			if output == "" {
+				_, err = os.Stdout.Write(translated)
+				return err
+			}
+			return ioutil.WriteFile(output, translated, 0644)
+		},
+	}
+	cmd.Flags().StringVar(&to, "to", token.French.Name, "locale to translate to (fr or en)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the result to this file instead of stdout")
 	return cmd
 }
 
-func repl(vm *old.VM) {
+func getLocale(name string) (*token.Locale, error) {
+	locale, ok := token.GetLocale(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown locale %q", name)
+	}
+	return locale, nil
+}
+
+func repl(vm *compiler.VM, format string) {
 	reader := bufio.NewReader(os.Stdin)
 	var buffer bytes.Buffer
 	for {
 		fmt.Print("> ")
 		read, _ := reader.ReadString('\n')
 		buffer.WriteString(read)
-		vm.Interpret(buffer.Bytes())
+		if _, err := vm.Interpret(context.Background(), buffer.Bytes()); err != nil {
+			printError(os.Stderr, err, buffer.Bytes(), format)
+		}
 	}
 }
 
-func runFile(path string, vm *old.VM) {
+func runFile(vm *compiler.VM, path string, format string) {
 	fileBytes := readFile(path)
-	result := vm.Interpret(fileBytes)
 
-	if result == old.InterpretCompileError {
+	var result compiler.InterpretResult
+	var err error
+	if chunk.IsBytecode(fileBytes) {
+		fn, readErr := chunk.ReadBytecode(bytes.NewReader(fileBytes))
+		if readErr != nil {
+			handleError(fmt.Errorf("%s: %v", path, readErr))
+		}
+		result, err = vm.Run(context.Background(), fn)
+		var verifyErr *compiler.VerifyError
+		if errors.As(err, &verifyErr) {
+			err = fmt.Errorf("%s: %v", path, err)
+		}
+	} else {
+		result, err = vm.Interpret(context.Background(), fileBytes)
+	}
+
+	if err != nil {
+		printError(os.Stderr, err, fileBytes, format)
+	}
+	if result == compiler.InterpretCompileError {
 		os.Exit(65)
 	}
-	if result == old.InterpretRuntimeError {
+	if result == compiler.InterpretRuntimeError {
 		os.Exit(70)
 	}
 }
 
+// printError writes to w the error of compiling or running source in the
+// given format. As text, the diagnostics of the compiler come with the
+// lines of source they are about.
+func printError(w io.Writer, err error, source []byte, format string) {
+	var diagnostics compiler.Diagnostics
+	var runtimeErr *compiler.RuntimeError
+	switch {
+	case errors.As(err, &diagnostics):
+	case errors.As(err, &runtimeErr):
+		if format == formatText {
+			fmt.Fprintln(w, runtimeErr)
+			return
+		}
+		diagnostics = compiler.Diagnostics{runtimeErr.Diagnostic()}
+	default:
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	if format == formatJSON {
+		if err := compiler.FprintDiagnosticsJSON(w, diagnostics); err != nil {
+			handleError(err)
+		}
+		return
+	}
+	compiler.FprintDiagnostics(w, diagnostics, source)
+}
+
 func readFile(path string) []byte {
 	file, err := os.Open(path)
 	if err != nil {
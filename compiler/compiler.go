@@ -5,8 +5,9 @@ import (
 	"GNBS/scanner"
 	"GNBS/token"
 	"fmt"
+	gotoken "go/token"
+	"io"
 	"math"
-	"os"
 	"strconv"
 )
 
@@ -15,596 +16,1316 @@ type Parser struct {
 	previous *scanner.Token
 	scanner  *scanner.Scanner
 
-	hadError  bool
-	panicMode bool
+	panicMode   bool
+	diagnostics Diagnostics
+
+	types   []*chunk.Type
+	globals map[string]*chunk.Type
+	classes map[string]*chunk.ClassType
+}
+
+// Options configure a Compiler or a VM.
+type Options struct {
+	// Locale is the spelling of the reserved words in the sources that
+	// don't carry a //gnbs:locale pragma. It defaults to French.
+	Locale *token.Locale
+
+	// Filename is the name of the source file, reported in the positions
+	// of errors.
+	Filename string
+
+	// Stdout is where afficher writes. It defaults to os.Stdout.
+	Stdout io.Writer
+
+	// Globals are the static types of the globals defined by the host
+	// before the sources run, such as native functions.
+	Globals map[string]*chunk.Type
+
+	// NoOptimize turns constant folding off, so that each operator of the
+	// source is left for the VM.
+	NoOptimize bool
 }
 
+// Compiler compiles GNBS sources to bytecode. It holds all of its state, so
+// separate Compilers may be used from separate goroutines; a single one
+// compiles one source at a time.
 type Compiler struct {
-	Enclosing *Compiler
+	opts Options
+
+	parser       *Parser
+	current      *FunctionCompiler
+	currentClass *ClassCompiler
+
+	// leftStart is the offset in the code of the left operand of the infix
+	// operator being compiled.
+	leftStart int
+	// folder is the VM that computes folded constants.
+	folder *VM
+}
+
+// FunctionCompiler is the state of the function being compiled, the
+// top-level script included.
+type FunctionCompiler struct {
+	Enclosing *FunctionCompiler
 	Function  *chunk.GFunction
 	Type      FunctionType
 
 	Locals     []Local
 	LocalCount int
 	ScoreDepth int
+	Upvalues   []Upvalue
+	Loop       *Loop
+
+	// ReturnType is the declared return type, or the one inferred from the
+	// first return statement when none is declared.
+	ReturnType *chunk.Type
+	// Terminated is set when the end of the last statement compiled can't
+	// be reached, as after a return statement.
+	Terminated bool
 }
 
 type Local struct {
-	Name  *scanner.Token
-	Depth int
+	Name       *scanner.Token
+	Depth      int
+	Type       *chunk.Type
+	IsCaptured bool
 }
 
-var (
-	parser  *Parser
-	current *Compiler = nil
-)
+// Upvalue is a variable of an enclosing function used by the one being
+// compiled: a local of the function right around it when IsLocal is set,
+// one of that function's own upvalues otherwise.
+type Upvalue struct {
+	Index   byte
+	IsLocal bool
+	Type    *chunk.Type
+}
 
-func InitParser() {
+// Loop is a pendant loop being compiled: casser jumps out of it and
+// continuer back to Start, after popping the locals deeper than ScopeDepth.
+type Loop struct {
+	Enclosing  *Loop
+	Start      int
+	ScopeDepth int
+	Breaks     []int
+}
 
+type ClassCompiler struct {
+	Enclosing     *ClassCompiler
+	Type          *chunk.ClassType
+	HasSuperclass bool
 }
 
-func InitCompiler(comp *Compiler, Type FunctionType) {
-	comp = &Compiler{Enclosing: current, Function: nil, Type: Type, LocalCount: 0, ScoreDepth: 0, Locals: make([]Local, math.MaxUint8+1)}
+// initializerName is the name of the method called when a class is called
+// to build an instance.
+const initializerName = "init"
+
+func New(opts Options) *Compiler {
+	if opts.Locale == nil {
+		opts.Locale = token.French
+	}
+	return &Compiler{opts: opts}
+}
+
+func (c *Compiler) initCompiler(comp *FunctionCompiler, Type FunctionType) {
+	*comp = FunctionCompiler{Enclosing: c.current, Function: nil, Type: Type, LocalCount: 0, ScoreDepth: 0, Locals: make([]Local, math.MaxUint8+1)}
 	comp.Function = chunk.NewGFunction()
 
-	current = comp
+	c.current = comp
 
-	if Type == TypeScript {
-		current.Function.Name = chunk.NewGString(parser.previous.LitName)
+	if Type != TypeScript {
+		c.current.Function.Name = chunk.NewGString(c.parser.previous.LitName)
 	}
 
-	local := &current.Locals[current.LocalCount]
-	current.LocalCount++
+	local := &c.current.Locals[c.current.LocalCount]
+	c.current.LocalCount++
 	local.Depth = 0
-	local.Name.LitName = ""
+	local.Name = &scanner.Token{}
+	local.Type = chunk.AnyType
+	if Type == TypeMethod || Type == TypeInitializer {
+		local.Name = syntheticToken(token.This, c.parser.previous)
+		local.Type = c.currentClass.Type.InstanceType()
+	}
 }
 
-// Parser
-
-func GetParser() *Parser {
-	return parser
+// Compile compiles source with a new Compiler using the default options.
+func Compile(source []byte) (*chunk.GFunction, Diagnostics) {
+	return New(Options{}).Compile(source)
 }
 
-func Compile(source []byte) *chunk.GFunction {
-	parser.scanner = scanner.NewScanner(source, nil)
-	parser.hadError = false
+// Compile compiles source to the function of its top-level script. It
+// returns all the diagnostics it finds, going on after each syntax error
+// from the next statement, and a nil function if any of them is an error.
+func (c *Compiler) Compile(source []byte) (*chunk.GFunction, Diagnostics) {
+	c.parser = &Parser{
+		globals: make(map[string]*chunk.Type),
+		classes: make(map[string]*chunk.ClassType),
+	}
+	for name, t := range c.opts.Globals {
+		c.parser.globals[name] = t
+	}
+	c.current = nil
+	c.currentClass = nil
+	c.parser.scanner = scanner.NewFileScanner(c.opts.Filename, source, c.scanError)
+	c.parser.scanner.SetLocale(c.opts.Locale)
 
-	var compiler Compiler
-	InitCompiler(&compiler, TypeScript)
+	var compiler FunctionCompiler
+	c.initCompiler(&compiler, TypeScript)
 
-	advance()
+	c.advance()
 
-	for !match(token.Eof) {
-		declaration()
+	for !c.match(token.Eof) {
+		c.declaration()
 	}
 
-	function := endCompiler()
-	if parser.hadError {
-		return nil
+	function := c.endCompiler()
+	if c.parser.diagnostics.HasErrors() {
+		return nil, c.parser.diagnostics
 	}
-	return function
+	return function, c.parser.diagnostics
 }
 
-func advance() {
-	parser.previous = parser.current
+func (c *Compiler) advance() {
+	c.parser.previous = c.parser.current
 
 	for {
-		parser.current = parser.scanner.Scan()
-		if parser.current.Token != token.Error {
+		c.parser.current = c.parser.scanner.Scan()
+		if c.parser.current.Token != token.Error {
 			break
 		}
 
-		errorAtCurrent(parser.current.LitName)
+		c.errorAtCurrent(c.parser.current.LitName)
 	}
 }
 
-func consume(tp token.TokenType, message string) {
-	if parser.current.Token == tp {
-		advance()
+func (c *Compiler) consume(tp token.TokenType, message string) {
+	if c.parser.current.Token == tp {
+		c.advance()
 		return
 	}
 
-	errorAtCurrent(message)
+	c.errorAtCurrent(message)
 }
 
-func declaration() {
-	if match(token.Function) {
-		funcDeclaration()
-	} else if match(token.Var) {
-		varDeclaration()
+func (c *Compiler) declaration() {
+	// The empty statement after a '}' doesn't make the end of the block
+	// reachable again.
+	if !c.check(token.Semicolon) {
+		c.current.Terminated = false
+	}
+
+	if c.match(token.Class) {
+		c.classDeclaration()
+	} else if c.match(token.Function) {
+		c.funcDeclaration()
+	} else if c.match(token.Var) {
+		c.varDeclaration()
 	} else {
-		statement()
+		c.statement()
 	}
 
-	if parser.panicMode {
-		synchronize()
+	if c.parser.panicMode {
+		c.synchronize()
 	}
 }
 
-func synchronize() {
-	parser.panicMode = false
+func (c *Compiler) synchronize() {
+	c.parser.panicMode = false
+	c.parser.types = c.parser.types[:0]
 
-	for parser.current.Token != token.Eof {
-		if parser.previous.Token != token.Semicolon {
+	for c.parser.current.Token != token.Eof {
+		if c.parser.previous.Token == token.Semicolon {
 			return
 		}
 
-		switch parser.current.Token {
+		switch c.parser.current.Token {
 		case token.Class, token.Function, token.Var, token.For, token.If, token.Print, token.Return:
 			return
 		default:
 
 		}
-		advance()
+		c.advance()
 	}
 }
 
-func match(tk token.TokenType) bool {
-	if !check(tk) {
+func (c *Compiler) match(tk token.TokenType) bool {
+	if !c.check(tk) {
 		return false
 	}
-	advance()
+	c.advance()
 	return true
 }
 
-func check(tk token.TokenType) bool {
-	return parser.current.Token == tk
+func (c *Compiler) check(tk token.TokenType) bool {
+	return c.parser.current.Token == tk
 }
 
-func beginScope() {
-	current.ScoreDepth++
+func (c *Compiler) beginScope() {
+	c.current.ScoreDepth++
 }
 
-func endScope() {
-	current.ScoreDepth--
+func (c *Compiler) endScope() {
+	c.current.ScoreDepth--
 
-	for current.LocalCount > 0 && current.Locals[current.LocalCount-1].Depth > current.ScoreDepth {
-		emitByte(OpPop)
-		current.LocalCount--
+	for c.current.LocalCount > 0 && c.current.Locals[c.current.LocalCount-1].Depth > c.current.ScoreDepth {
+		if c.current.Locals[c.current.LocalCount-1].IsCaptured {
+			c.emitByte(OpCloseUpvalue)
+		} else {
+			c.emitByte(OpPop)
+		}
+		c.current.LocalCount--
 	}
 }
 
-func endCompiler() *chunk.GFunction {
-	emitReturn()
-	fn := current.Function
+func (c *Compiler) endCompiler() *chunk.GFunction {
+	c.emitReturn()
+	fn := c.current.Function
+	c.checkStack(fn)
 
-	current = current.Enclosing
+	c.current = c.current.Enclosing
 	return fn
 }
 
+// checkStack reports an error if a call to fn would use more than
+// FrameSlots stack slots, which the VM would refuse to run.
+func (c *Compiler) checkStack(fn *chunk.GFunction) {
+	if c.parser.diagnostics.HasErrors() {
+		return
+	}
+	v := verifier{fn: fn, code: fn.Chunk.Code}
+	if err := v.verify(); err != nil && v.overflow {
+		pos := fn.Chunk.Position(err.(*VerifyError).Offset)
+		c.report(&Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeSyntax,
+			Message:  "Too many values on the stack.",
+			Span:     Span{Start: pos, End: pos},
+		})
+	}
+}
+
 // Statement Handlers
 
-func statement() {
-	if match(token.Print) {
-		printStatement()
-	} else if match(token.For) {
-		forStatement()
-	} else if match(token.If) {
-		ifStatement()
-	} else if match(token.Return) {
-		returnStatement()
-	}else {
-		expressionStatement()
+func (c *Compiler) statement() {
+	if c.match(token.Print) {
+		c.printStatement()
+	} else if c.match(token.For) {
+		c.forStatement()
+	} else if c.match(token.If) {
+		c.ifStatement()
+	} else if c.match(token.Return) {
+		c.returnStatement()
+	} else if c.match(token.Break) {
+		c.breakStatement()
+	} else if c.match(token.Continue) {
+		c.continueStatement()
+	} else if c.match(token.LBrace) {
+		c.beginScope()
+		c.block()
+		c.endScope()
+	} else if c.match(token.Semicolon) {
+		// An empty statement, such as the one inserted after a '}'.
+	} else if c.matchSimpleStatement() {
+		c.endStatement("Expect ';' after statement.")
+	} else {
+		c.expressionStatement()
 	}
 }
 
-func printStatement() {
-	expression()
-	consume(token.Semicolon, "Expect ';' after value.")
-	emitByte(OpPrint)
-}
+// matchSimpleStatement compiles a short variable declaration or an
+// increment or decrement if one starts at the current token, without the
+// ';' that ends it, and reports whether it did.
+func (c *Compiler) matchSimpleStatement() bool {
+	if !c.check(token.Identifier) {
+		return false
+	}
 
-func expressionStatement() {
-	expression()
-	consume(token.Semicolon, "Expect ';' after expression.")
-	emitByte(OpPop)
+	switch c.parser.scanner.Peek().Token {
+	case token.Define:
+		c.shortVarDeclaration()
+		return true
+	case token.Increment, token.Decrement:
+		c.incDecStatement()
+		return true
+	}
+	return false
 }
 
-func ifStatement() {
-	consume(token.LParentheses, "Expect '(' after 'if'.")
-	expression()
-	consume(token.RParentheses, "Expect ')' after condition.")
+// incDecStatement compiles 'name++' and 'name--'.
+func (c *Compiler) incDecStatement() {
+	c.advance()
+	name := c.parser.previous
+	getOp, setOp, arg, varType := c.resolveVariable(name)
 
-	thenJump := emitJump(OpJumpIfFalse)
-	emitByte(OpPop)
-	statement()
-
-	elseJump := emitJump(OpJump)
+	c.advance()
+	operator := c.parser.previous
+	if !varType.IsAny() && !varType.IsNumeric() {
+		c.typeError(operator, "operator '%s' not defined on %s", operator.Token, varType)
+	}
 
-	patchJump(thenJump)
-	emitByte(OpPop)
+	c.emitOperand(getOp, arg)
+	if varType.Kind == chunk.TypeFloat {
+		c.emitConstant(chunk.Value{Type: chunk.TypeFloat, Value: 1.0})
+	} else {
+		c.emitConstant(chunk.Value{Type: chunk.TypeInteger, Value: int64(1)})
+	}
+	if operator.Token == token.Increment {
+		c.emitByte(OpAdd)
+	} else {
+		c.emitByte(OpSubtract)
+	}
+	c.emitOperand(setOp, arg)
+	c.emitByte(OpPop)
+}
 
-	if match(token.Else) {
-		statement()
+// endStatement consumes the ';' that ends a simple statement. The '}' of a
+// block and the end of the source end one as well, so that a block can
+// hold a statement on the same line.
+func (c *Compiler) endStatement(message string) {
+	if !c.check(token.RBrace) && !c.check(token.Eof) {
+		c.consume(token.Semicolon, message)
 	}
-	patchJump(elseJump)
 }
 
-func forStatement() {
-	beginScope()
+func (c *Compiler) printStatement() {
+	c.expression()
+	c.popType()
+	c.endStatement("Expect ';' after value.")
+	c.emitByte(OpPrint)
+}
+
+func (c *Compiler) expressionStatement() {
+	c.expression()
+	c.popType()
+	c.endStatement("Expect ';' after expression.")
+	c.emitByte(OpPop)
+}
+
+// ifStatement compiles a si, any number of sinon si and an optional autre.
+// The branch that runs jumps straight to the end of the whole chain, which
+// terminates when it has an autre and all its blocks do.
+func (c *Compiler) ifStatement() {
+	var endJumps []int
+	terminated := true
+
+	for {
+		c.expression()
+		c.checkCondition(c.parser.previous)
+
+		thenJump := c.emitJump(OpJumpIfFalse)
+		c.emitByte(OpPop)
+		c.scopedBlock()
+		terminated = terminated && c.current.Terminated
+		endJumps = append(endJumps, c.emitJump(OpJump))
+
+		c.patchJump(thenJump)
+		c.emitByte(OpPop)
 
-	consume(token.LParentheses, "Expect '(' after 'for'.")
-	if match(token.Semicolon) {
+		if !c.matchAfterBlock(token.ElseIf) {
+			break
+		}
+	}
 
-	} else if match(token.Var) {
-		varDeclaration()
+	if c.matchAfterBlock(token.Else) {
+		c.scopedBlock()
+		terminated = terminated && c.current.Terminated
 	} else {
-		expressionStatement()
+		terminated = false
+	}
+	for _, jump := range endJumps {
+		c.patchJump(jump)
 	}
+	c.current.Terminated = terminated
+}
 
-	loopStart := uint16(len(currentChunk().Code))
+// matchAfterBlock matches tk on the line that follows a '}' as well as on
+// the same line, so that sinon si and autre may start their own line.
+func (c *Compiler) matchAfterBlock(tk token.TokenType) bool {
+	if c.check(token.Semicolon) && c.parser.current.LitName == "\n" && c.parser.scanner.Peek().Token == tk {
+		c.advance()
+	}
+	return c.match(tk)
+}
 
+// forStatement compiles the three forms of 'pendant':
+//
+//	pendant init; condition; post {}
+//	pendant condition {}
+//	pendant {}
+//
+// Any clause of the first form may be left empty. A loop without a
+// condition or a casser never ends.
+func (c *Compiler) forStatement() {
+	c.beginScope()
+	loop := Loop{Enclosing: c.current.Loop, ScopeDepth: c.current.ScoreDepth}
+
+	loopStart := len(c.currentChunk().Code)
 	exitJump := -1
-	if !match(token.Semicolon) {
-		expression()
-		consume(token.Semicolon, "Expect ';' after loop condition.")
 
-		exitJump = emitJump(OpJumpIfFalse)
-		emitByte(OpPop)
-	}
+	if !c.check(token.LBrace) {
+		// The first clause is the condition unless a ';' follows it.
+		threeClauses := true
+		if c.matchSimpleStatement() {
+			c.consume(token.Semicolon, "Expect ';' after loop initializer.")
+		} else if !c.match(token.Semicolon) {
+			c.expression()
+			if c.match(token.Semicolon) {
+				c.popType()
+				c.emitByte(OpPop)
+			} else {
+				threeClauses = false
+				c.checkCondition(c.parser.previous)
+				exitJump = c.emitJump(OpJumpIfFalse)
+				c.emitByte(OpPop)
+			}
+		}
 
-	consume(token.RParentheses, "Expect ')' after for clauses.")
+		if threeClauses {
+			loopStart = len(c.currentChunk().Code)
+			if !c.check(token.Semicolon) {
+				c.expression()
+				c.checkCondition(c.parser.previous)
+				exitJump = c.emitJump(OpJumpIfFalse)
+				c.emitByte(OpPop)
+			}
+			c.consume(token.Semicolon, "Expect ';' after loop condition.")
 
-	if !match(token.RParentheses) {
-		bodyJump := emitJump(OpJump)
+			if !c.check(token.LBrace) {
+				bodyJump := c.emitJump(OpJump)
 
-		incrementStart := len(currentChunk().Code)
-		expression()
-		emitByte(OpPop)
-		consume(token.RParentheses, "Expect ')' after for clauses.")
+				incrementStart := len(c.currentChunk().Code)
+				if !c.matchSimpleStatement() {
+					c.expression()
+					c.popType()
+					c.emitByte(OpPop)
+				}
 
-		emitLoop(loopStart)
-		loopStart = uint16(incrementStart)
-		patchJump(bodyJump)
+				c.emitLoop(loopStart)
+				loopStart = incrementStart
+				c.patchJump(bodyJump)
+			}
+		}
 	}
 
-	statement()
-	emitLoop(loopStart)
+	loop.Start = loopStart
+	c.current.Loop = &loop
+	c.scopedBlock()
+	c.current.Loop = loop.Enclosing
+	c.emitLoop(loopStart)
 
 	if exitJump != -1 {
-		patchJump(exitJump)
-		emitByte(OpPop)
+		c.patchJump(exitJump)
+		c.emitByte(OpPop)
+	}
+	for _, jump := range loop.Breaks {
+		c.patchJump(jump)
 	}
 
-	endScope()
+	c.endScope()
+	c.current.Terminated = exitJump == -1 && len(loop.Breaks) == 0
 }
 
-func returnStatement() {
-	if current.Type == TypeScript {
-		error("Can't return from top-level code.")
+// breakStatement compiles casser, which leaves the innermost loop.
+func (c *Compiler) breakStatement() {
+	if c.current.Loop == nil {
+		c.error(fmt.Sprintf("Can't use '%s' outside of a loop.", c.parser.previous.LitName))
+	} else {
+		c.discardLocals(c.current.Loop.ScopeDepth)
+		c.current.Loop.Breaks = append(c.current.Loop.Breaks, c.emitJump(OpJump))
 	}
+	c.endStatement("Expect ';' after break.")
+}
 
-	if match(token.Semicolon) {
-		emitReturn()
+// continueStatement compiles continuer, which starts the next iteration of
+// the innermost loop, running the post statement of a three-clause loop.
+func (c *Compiler) continueStatement() {
+	if c.current.Loop == nil {
+		c.error(fmt.Sprintf("Can't use '%s' outside of a loop.", c.parser.previous.LitName))
 	} else {
-		expression()
-		consume(token.Semicolon, "Expect ';' after return value.")
-		emitByte(OpReturn)
+		c.discardLocals(c.current.Loop.ScopeDepth)
+		c.emitLoop(c.current.Loop.Start)
+	}
+	c.endStatement("Expect ';' after continue.")
+}
+
+// discardLocals pops the locals deeper than depth off the stack at run time
+// without ending their scope, for a jump out of it.
+func (c *Compiler) discardLocals(depth int) {
+	for i := c.current.LocalCount - 1; i >= 0 && c.current.Locals[i].Depth > depth; i-- {
+		if c.current.Locals[i].IsCaptured {
+			c.emitByte(OpCloseUpvalue)
+		} else {
+			c.emitByte(OpPop)
+		}
 	}
 }
 
+func (c *Compiler) returnStatement() {
+	keyword := c.parser.previous
+	if c.current.Type == TypeScript {
+		c.error("Can't return from top-level code.")
+	}
+
+	if c.match(token.Semicolon) || c.check(token.RBrace) || c.check(token.Eof) {
+		c.checkReturn(keyword, chunk.NullType)
+		c.emitReturn()
+	} else {
+		if c.current.Type == TypeInitializer {
+			c.error("Can't return a value from an initializer.")
+		}
+		c.expression()
+		c.checkReturn(keyword, c.popType())
+		c.endStatement("Expect ';' after return value.")
+		c.emitByte(OpReturn)
+	}
+	c.current.Terminated = true
+}
+
+func (c *Compiler) checkReturn(keyword *scanner.Token, t *chunk.Type) {
+	if c.current.ReturnType == nil {
+		c.current.ReturnType = t
+		return
+	}
+	c.checkAssignable(keyword, t, c.current.ReturnType, "return statement")
+}
+
 // Expression handlers
 
-func expression() {
-	parsePrecedence(Assignment)
+func (c *Compiler) expression() {
+	c.parsePrecedence(Assignment)
 }
 
-func block() {
-	for !check(token.RBrace) && !check(token.Eof) {
-		declaration()
+func (c *Compiler) block() {
+	for !c.check(token.RBrace) && !c.check(token.Eof) {
+		c.declaration()
 	}
 
-	consume(token.RBrace, "Expect '}' after block.")
+	c.consume(token.RBrace, "Expect '}' after block.")
+}
+
+// scopedBlock compiles the braces of a si or pendant body, whose locals
+// are popped when the block ends. An empty block terminates only if the
+// statement before it did, so Terminated starts over.
+func (c *Compiler) scopedBlock() {
+	c.current.Terminated = false
+	c.consume(token.LBrace, "Expect '{' before block.")
+	c.beginScope()
+	c.block()
+	c.endScope()
 }
 
-func function(functionType FunctionType) {
-	var compiler Compiler
-	InitCompiler(&compiler, functionType)
-	beginScope()
+// function compiles a parameter list, return type and body, filling in
+// fnType as soon as the signature is known so that the body can call itself.
+func (c *Compiler) function(functionType FunctionType, fnType *chunk.Type) {
+	var compiler FunctionCompiler
+	c.initCompiler(&compiler, functionType)
+	c.beginScope()
 
-	consume(token.LParentheses, "Expect '(' after function name.")
-	if !check(token.RParentheses) {
+	c.consume(token.LParentheses, "Expect '(' after function name.")
+	if !c.check(token.RParentheses) {
 		firstRun := true
-		for firstRun || match(token.Comma) {
+		for firstRun || c.match(token.Comma) {
 			firstRun = false
 
-			current.Function.Arity++
-			if current.Function.Arity > 255 {
-				errorAtCurrent("Can't have more than 255 parameters")
+			c.current.Function.Arity++
+			if c.current.Function.Arity > 255 {
+				c.errorAtCurrent("Can't have more than 255 parameters")
 			}
 
-			paramConstant := parseVariable("Expect parameter name.")
-			defineVariable(paramConstant)
+			paramConstant := c.parseVariable("Expect parameter name.")
+			paramType := chunk.AnyType
+			if c.match(token.Colon) {
+				paramType = c.parseType()
+			}
+			c.current.Locals[c.current.LocalCount-1].Type = paramType
+			c.current.Function.ParamTypes = append(c.current.Function.ParamTypes, paramType)
+			c.defineVariable(paramConstant)
+		}
+	}
+	c.consume(token.RParentheses, "Expect ')' after parameters.")
+
+	if c.match(token.Arrow) {
+		if functionType == TypeInitializer {
+			c.error("Can't declare a return type for an initializer.")
 		}
+		c.current.ReturnType = c.parseType()
+		fnType.Params, fnType.Return = c.current.Function.ParamTypes, c.current.ReturnType
 	}
-	consume(token.RParentheses, "Expect ')' after parameters.")
 
-	consume(token.LBrace, "Expect '{' before function body.")
+	c.consume(token.LBrace, "Expect '{' before function body.")
 
-	block()
+	c.block()
 
-	fun := endCompiler()
-	emitBytes(OpConstant, makeConstant(chunk.Value{
+	// Falling off the end of the body returns nul.
+	if t := c.current.ReturnType; t != nil && !c.current.Terminated && !chunk.NullType.AssignableTo(t) {
+		c.typeError(c.parser.previous, "missing return at the end of a function returning %s", t)
+	}
+	if c.current.ReturnType == nil {
+		c.current.ReturnType = chunk.NullType
+	}
+	c.current.Function.ReturnType = c.current.ReturnType
+	fnType.Params, fnType.Return = c.current.Function.ParamTypes, c.current.ReturnType
+
+	fun := c.endCompiler()
+	c.emitOperand(OpClosure, c.makeConstant(chunk.Value{
 		Type:  chunk.TypeFunction,
 		Value: fun,
 	}))
+
+	for _, upvalue := range compiler.Upvalues {
+		if upvalue.IsLocal {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitByte(upvalue.Index)
+	}
+}
+
+// parseType parses a type annotation: one of the basic types, fonction for
+// a function of any signature, or the name of a class for its instances.
+func (c *Compiler) parseType() *chunk.Type {
+	switch {
+	case c.match(token.IntType):
+		return chunk.IntType
+	case c.match(token.FloatType):
+		return chunk.FloatType
+	case c.match(token.StringType):
+		return chunk.StringType
+	case c.match(token.BoolType):
+		return chunk.BoolType
+	case c.match(token.Function):
+		return &chunk.Type{Kind: chunk.TypeFunction}
+	case c.match(token.Identifier):
+		if class, ok := c.parser.classes[c.parser.previous.LitName]; ok {
+			return class.InstanceType()
+		}
+		c.error(fmt.Sprintf("Unknown type '%s'.", c.parser.previous.LitName))
+		return chunk.AnyType
+	}
+
+	c.errorAtCurrent("Expect type.")
+	return chunk.AnyType
 }
 
-func varDeclaration() {
-	global := parseVariable("Expect variable name.")
+func (c *Compiler) varDeclaration() {
+	global := c.parseVariable("Expect variable name.")
+	name := c.parser.previous
 
-	if match(token.Equal) {
-		expression()
+	varType := chunk.AnyType
+	declared := c.match(token.Colon)
+	if declared {
+		varType = c.parseType()
+	}
+
+	if c.match(token.Equal) {
+		c.expression()
+		if declared {
+			c.checkAssignable(name, c.popType(), varType, "declaration of "+name.LitName)
+		} else {
+			varType = inferred(c.popType())
+		}
 	} else {
-		emitByte(OpNull)
+		c.emitZeroValue(varType)
 	}
-	consume(token.Semicolon, "Expect ';' after variable declaration.")
+	c.endStatement("Expect ';' after variable declaration.")
 
-	defineVariable(global)
+	c.setVariableType(name, varType)
+	c.defineVariable(global)
 }
 
-func funcDeclaration() {
-	global := parseVariable("Expect function name.")
-	markInitialized()
-	function(TypeFunction)
-	defineVariable(global)
+// shortVarDeclaration compiles 'name := expression', which declares a
+// variable with the type of its initial value.
+func (c *Compiler) shortVarDeclaration() {
+	global := c.parseVariable("Expect variable name.")
+	name := c.parser.previous
+
+	c.consume(token.Define, "Expect ':=' after variable name.")
+	c.expression()
+	varType := inferred(c.popType())
+
+	c.setVariableType(name, varType)
+	c.defineVariable(global)
 }
 
-func grouping(canAssign bool) {
-	expression()
-	consume(token.RParentheses, "Expect ')' after expression.")
+// classDeclaration compiles a class. Its fields are declared with their
+// type and every instance starts out with their zero values; the method
+// called init, if any, receives the arguments of the call to the class.
+// A class given a superclass with '<' starts out with a copy of its
+// fields and methods, and the superclass is kept in a local named super
+// for the methods to capture.
+func (c *Compiler) classDeclaration() {
+	c.consume(token.Identifier, "Expect class name.")
+	className := c.parser.previous
+	nameConstant := c.identifierConstant(className)
+	c.declareVariable()
+
+	classType := chunk.NewClassType(className.LitName)
+	c.parser.classes[className.LitName] = classType
+	c.setVariableType(className, &chunk.Type{Kind: chunk.TypeClass, Class: classType})
+
+	c.emitOperand(OpClass, nameConstant)
+	c.defineVariable(nameConstant)
+
+	classCompiler := ClassCompiler{Enclosing: c.currentClass, Type: classType}
+	c.currentClass = &classCompiler
+
+	if c.match(token.Less) {
+		c.consume(token.Identifier, "Expect superclass name.")
+		superName := c.parser.previous
+		c.variable(false)
+		if identifiersEqual(className, superName) {
+			c.error("A class can't inherit from itself.")
+		}
+		if superType := c.popType(); superType.Kind != chunk.TypeClass {
+			c.typeError(superName, "cannot inherit from non-class %s", superType)
+		} else if superType.Class != classType {
+			// Each declaration makes a new ClassType, even for a name
+			// already used, and names its superclass before its body:
+			// any superclass other than the class itself, reported above,
+			// was complete before this one existed and can't inherit from
+			// it, so no cycle can be built.
+			classType.Inherit(superType.Class)
+		}
+
+		c.beginScope()
+		c.addLocal(syntheticToken(token.Super, superName))
+		c.defineVariable(0)
+
+		c.namedVariable(className, false)
+		c.popType()
+		c.emitByte(OpInherit)
+		classCompiler.HasSuperclass = true
+	}
+
+	c.namedVariable(className, false)
+	c.popType()
+	c.consume(token.LBrace, "Expect '{' before class body.")
+	for !c.check(token.RBrace) && !c.check(token.Eof) {
+		if !c.match(token.Semicolon) {
+			c.member()
+		}
+	}
+	c.consume(token.RBrace, "Expect '}' after class body.")
+	c.emitByte(OpPop)
+
+	if classCompiler.HasSuperclass {
+		c.endScope()
+	}
+	c.currentClass = c.currentClass.Enclosing
 }
 
-func unary(canAssign bool) {
-	operatorType := parser.previous.Token
+// member compiles a field, 'name: Type', or a method of the class being
+// compiled. A method may override an inherited one with a compatible
+// signature; everything else must have a name of its own.
+func (c *Compiler) member() {
+	c.consume(token.Identifier, "Expect field or method name.")
+	name := c.parser.previous
+	constant := c.identifierConstant(name)
+
+	class := c.currentClass.Type
+	var inherited *chunk.Type
+	isInherited := false
+	if class.Super != nil {
+		inherited, isInherited = class.Super.Property(name.LitName)
+	}
+	if t, ok := class.Property(name.LitName); ok && t != inherited {
+		c.error("Already a field or method with this name in this class.")
+	}
+
+	if c.match(token.Colon) {
+		if isInherited {
+			c.error("Already a field or method with this name in a superclass.")
+		}
+		fieldType := c.parseType()
+		c.currentClass.Type.Fields[name.LitName] = fieldType
+		c.emitZeroValue(fieldType)
+		c.emitOperand(OpField, constant)
+		if !c.check(token.RBrace) {
+			c.consume(token.Semicolon, "Expect ';' after field declaration.")
+		}
+		return
+	}
 
-	parsePrecedence(Unary)
+	methodType := &chunk.Type{Kind: chunk.TypeFunction}
+	c.currentClass.Type.Methods[name.LitName] = methodType
+	functionType := TypeMethod
+	if name.LitName == initializerName {
+		functionType = TypeInitializer
+	}
+	if _, isField := class.Fields[name.LitName]; isInherited && isField {
+		c.error("Already a field or method with this name in a superclass.")
+	}
+	c.function(functionType, methodType)
+	c.emitOperand(OpMethod, constant)
+
+	if isInherited && functionType == TypeMethod {
+		c.checkAssignable(name, methodType, inherited, "override of "+name.LitName)
+	}
+}
+
+func (c *Compiler) funcDeclaration() {
+	global := c.parseVariable("Expect function name.")
+	fnType := &chunk.Type{Kind: chunk.TypeFunction}
+	c.setVariableType(c.parser.previous, fnType)
+	c.markInitialized()
+	c.function(TypeFunction, fnType)
+	c.defineVariable(global)
+}
+
+// setVariableType records the type of the variable being declared, the last
+// local in a scope or a global at the top level.
+func (c *Compiler) setVariableType(name *scanner.Token, t *chunk.Type) {
+	if c.current.ScoreDepth > 0 {
+		c.current.Locals[c.current.LocalCount-1].Type = t
+		return
+	}
+	c.parser.globals[name.LitName] = t
+}
+
+func (c *Compiler) grouping(canAssign bool) {
+	c.expression()
+	c.consume(token.RParentheses, "Expect ')' after expression.")
+}
+
+func (c *Compiler) unary(canAssign bool) {
+	operator := c.parser.previous
+	operatorType := operator.Token
+
+	start := len(c.currentChunk().Code)
+	c.parsePrecedence(Unary)
+	operand := c.popType()
 
 	switch operatorType {
 	case token.Not:
-		emitByte(OpNot)
+		c.checkBoolean(operator, operand)
+		c.emitOperator(start, OpNot)
+		c.pushType(chunk.BoolType)
 		break
 	case token.Minus:
-		emitByte(OpNegate)
+		if !operand.IsAny() && !operand.IsNumeric() {
+			c.typeError(operator, "operator '-' not defined on %s", operand)
+		}
+		c.emitOperator(start, OpNegate)
+		c.pushType(operand)
 		break
 	default:
+		c.pushType(operand)
 		return
 	}
 }
 
-func binary(canAssign bool) {
-	operatorType := parser.previous.Token
+func (c *Compiler) binary(canAssign bool) {
+	operator := c.parser.previous
+	operatorType := operator.Token
 	rule := getRule(operatorType)
-	parsePrecedence(rule.precedence + 1)
+	start := c.leftStart
+	if operatorType == token.Power {
+		// Right-associative: 2 ** 3 ** 2 is 2 ** (3 ** 2).
+		c.parsePrecedence(rule.precedence)
+	} else {
+		c.parsePrecedence(rule.precedence + 1)
+	}
+
+	right, left := c.popType(), c.popType()
 
 	switch operatorType {
 	case token.Plus:
-		emitByte(OpAdd)
+		c.emitOperator(start, OpAdd)
+		c.pushType(c.arithmeticType(operator, left, right))
 		break
 	case token.Minus:
-		emitByte(OpSubtract)
+		c.emitOperator(start, OpSubtract)
+		c.pushType(c.arithmeticType(operator, left, right))
 		break
 	case token.Star:
-		emitByte(OpMultiply)
+		c.emitOperator(start, OpMultiply)
+		c.pushType(c.arithmeticType(operator, left, right))
 		break
 	case token.Slash:
-		emitByte(OpDivide)
+		c.emitOperator(start, OpDivide)
+		c.pushType(c.arithmeticType(operator, left, right))
+		break
+	case token.Mod:
+		c.emitOperator(start, OpModulo)
+		c.pushType(c.arithmeticType(operator, left, right))
+		break
+	case token.Div:
+		c.emitOperator(start, OpFloorDivide)
+		c.pushType(c.arithmeticType(operator, left, right))
+		break
+	case token.Power:
+		c.emitOperator(start, OpPower)
+		c.pushType(c.arithmeticType(operator, left, right))
 		break
 	case token.NotEqual:
-		emitBytes(OpEqual, OpNot)
+		c.emitOperator(start, OpEqual, OpNot)
+		c.pushType(c.equalityType(operator, left, right))
 		break
 	case token.EqualEqual:
-		emitByte(OpEqual)
+		c.emitOperator(start, OpEqual)
+		c.pushType(c.equalityType(operator, left, right))
 		break
 	case token.Greater:
-		emitByte(OpGreater)
+		c.emitOperator(start, OpGreater)
+		c.pushType(c.comparisonType(operator, left, right))
 		break
 	case token.GreaterEqual:
-		emitBytes(OpLess, OpNot)
+		c.emitOperator(start, OpLess, OpNot)
+		c.pushType(c.comparisonType(operator, left, right))
 		break
 	case token.Less:
-		emitByte(OpLess)
+		c.emitOperator(start, OpLess)
+		c.pushType(c.comparisonType(operator, left, right))
 		break
 	case token.LessEqual:
-		emitBytes(OpGreater, OpNot)
+		c.emitOperator(start, OpGreater, OpNot)
+		c.pushType(c.comparisonType(operator, left, right))
 	default:
+		c.pushType(chunk.AnyType)
 		break
 	}
 }
 
-func literal(canAssign bool) {
-	switch parser.previous.Token {
+func (c *Compiler) dot(canAssign bool) {
+	c.consume(token.Identifier, "Expect property name after '.'.")
+	name := c.parser.previous
+	constant := c.identifierConstant(name)
+	propType, isField := c.propertyType(name, c.popType())
+
+	if canAssign && c.match(token.Equal) {
+		c.expression()
+		if isField {
+			c.checkAssignable(name, c.popType(), propType, "assignment to "+name.LitName)
+		} else {
+			c.popType()
+			c.typeError(name, "cannot assign to method %s", name.LitName)
+		}
+		c.emitOperand(OpSetProperty, constant)
+	} else {
+		c.emitOperand(OpGetProperty, constant)
+	}
+	c.pushType(propType)
+}
+
+func (c *Compiler) this_(canAssign bool) {
+	if c.currentClass == nil {
+		c.error(fmt.Sprintf("Can't use '%s' outside of a class.", c.parser.previous.LitName))
+		c.pushType(chunk.AnyType)
+		return
+	}
+	c.namedVariable(syntheticToken(token.This, c.parser.previous), false)
+}
+
+// super_ compiles 'super.name', the method name of the superclass bound to
+// ceci.
+func (c *Compiler) super_(canAssign bool) {
+	keyword := c.parser.previous
+	if c.currentClass == nil {
+		c.error(fmt.Sprintf("Can't use '%s' outside of a class.", keyword.LitName))
+	} else if !c.currentClass.HasSuperclass {
+		c.error(fmt.Sprintf("Can't use '%s' in a class with no superclass.", keyword.LitName))
+	}
+
+	c.consume(token.Dot, "Expect '.' after 'super'.")
+	c.consume(token.Identifier, "Expect superclass method name.")
+	name := c.parser.previous
+	constant := c.identifierConstant(name)
+
+	methodType := chunk.AnyType
+	if c.currentClass != nil && c.currentClass.Type.Super != nil {
+		super := c.currentClass.Type.Super
+		if t, ok := super.Methods[name.LitName]; ok {
+			methodType = t
+		} else {
+			c.typeError(name, "%s has no method %s", super.Name, name.LitName)
+		}
+	}
+
+	c.namedVariable(syntheticToken(token.This, keyword), false)
+	c.namedVariable(syntheticToken(token.Super, keyword), false)
+	c.popType()
+	c.popType()
+	c.emitOperand(OpGetSuper, constant)
+	c.pushType(methodType)
+}
+
+// syntheticToken names the hidden locals of methods, ceci and super. Having
+// the keyword's token type, it never resolves to an identifier that is
+// spelled like it in another locale.
+func syntheticToken(keyword token.TokenType, at *scanner.Token) *scanner.Token {
+	return &scanner.Token{Position: at.Position, Token: keyword, LitName: keyword.String()}
+}
+
+func (c *Compiler) literal(canAssign bool) {
+	switch c.parser.previous.Token {
 	case token.False:
-		emitByte(OpFalse)
+		c.emitByte(OpFalse)
+		c.pushType(chunk.BoolType)
 		break
 	case token.Null:
-		emitByte(OpNull)
+		c.emitByte(OpNull)
+		c.pushType(chunk.NullType)
 		break
 	case token.True:
-		emitByte(OpTrue)
+		c.emitByte(OpTrue)
+		c.pushType(chunk.BoolType)
 		break
 	default:
 		return
 	}
 }
 
-func callFn(canAssign bool) {
-	argCount := argumentList()
-	emitBytes(OpCall, argCount)
+func (c *Compiler) callFn(canAssign bool) {
+	paren := c.parser.previous
+	args := c.argumentList()
+	callee := c.popType()
+	c.pushType(c.callType(paren, callee, args))
+	c.emitBytes(OpCall, byte(len(args)))
 }
 
 // Values functions
 
-func floatnumber(canAssign bool) {
-	value, _ := strconv.ParseFloat(parser.previous.LitName, 64)
+func (c *Compiler) floatnumber(canAssign bool) {
+	value, _ := strconv.ParseFloat(c.parser.previous.LitName, 64)
 	valueDS := chunk.Value{
 		Type:  chunk.TypeFloat,
 		Value: value,
 	}
-	emitConstant(valueDS)
+	c.emitConstant(valueDS)
+	c.pushType(chunk.FloatType)
 }
 
-func intnumber(canAssign bool) {
-	value, _ := strconv.ParseInt(parser.previous.LitName, 10, 64)
+func (c *Compiler) intnumber(canAssign bool) {
+	value, _ := strconv.ParseInt(c.parser.previous.LitName, 10, 64)
 	valueDS := chunk.Value{
 		Type:  chunk.TypeInteger,
 		Value: value,
 	}
-	emitConstant(valueDS)
+	c.emitConstant(valueDS)
+	c.pushType(chunk.IntType)
 }
 
-func stringvalue(canAssign bool) {
-	emitConstant(chunk.Value{
+func (c *Compiler) stringvalue(canAssign bool) {
+	value, _ := strconv.Unquote(c.parser.previous.LitName)
+	c.emitConstant(chunk.Value{
 		Type:  chunk.TypeString,
-		Value: chunk.NewGString(parser.previous.LitName),
+		Value: chunk.NewGString(value),
 	})
+	c.pushType(chunk.StringType)
 }
 
-func variable(canAssign bool) {
-	namedVariable(parser.previous, canAssign)
+func (c *Compiler) variable(canAssign bool) {
+	c.namedVariable(c.parser.previous, canAssign)
 }
 
-func makeConstant(value chunk.Value) byte {
-	constant := currentChunk().AddConstant(value)
-	if constant > math.MaxUint8 {
-		error("Too many constants in one chunk.")
+func (c *Compiler) makeConstant(value chunk.Value) int {
+	constant := c.currentChunk().AddConstant(value)
+	if constant > MaxConstants {
+		c.error("Too many constants in one chunk.")
 		return 0
 	}
 	return constant
 }
 
-func namedVariable(tk *scanner.Token, canAssign bool) {
-	arg := resolveLocal(current, tk)
-	var getOp, setOp byte
+func (c *Compiler) namedVariable(tk *scanner.Token, canAssign bool) {
+	getOp, setOp, arg, varType := c.resolveVariable(tk)
 
-	if arg != -1 {
-		getOp = OpGetLocal
-		setOp = OpSetLocal
+	if canAssign && c.match(token.Equal) {
+		c.expression()
+		c.checkAssignable(tk, c.popType(), varType, "assignment to "+tk.LitName)
+		c.emitOperand(setOp, arg)
 	} else {
-		arg = int(identifierConstant(tk))
-		getOp = OpGetGlobal
-		setOp = OpSetGlobal
+		c.emitOperand(getOp, arg)
 	}
+	c.pushType(varType)
+}
 
-	if canAssign && match(token.Equal) {
-		expression()
-		emitBytes(setOp, byte(arg))
+// resolveVariable finds how to read and write the variable named by tk,
+// and its type.
+func (c *Compiler) resolveVariable(tk *scanner.Token) (getOp, setOp byte, arg int, varType *chunk.Type) {
+	if local := resolveLocal(c.current, tk); local != -1 {
+		getOp, setOp, arg = OpGetLocal, OpSetLocal, local
+		varType = c.current.Locals[local].Type
+	} else if upvalue := c.resolveUpvalue(c.current, tk); upvalue != -1 {
+		getOp, setOp, arg = OpGetUpvalue, OpSetUpvalue, upvalue
+		varType = c.current.Upvalues[upvalue].Type
 	} else {
-		emitBytes(getOp, byte(arg))
+		getOp, setOp, arg = OpGetGlobal, OpSetGlobal, c.identifierConstant(tk)
+		varType = c.globalType(tk.LitName)
+	}
+	if varType == nil {
+		varType = chunk.AnyType
 	}
+	return
 }
 
-func and_(canAssign bool) {
-	endJump := emitJump(OpJumpIfFalse)
-	emitByte(OpPop)
-	parsePrecedence(And)
+func (c *Compiler) and_(canAssign bool) {
+	operator := c.parser.previous
+	c.checkBoolean(operator, c.popType())
+	endJump := c.emitJump(OpJumpIfFalse)
+	c.emitByte(OpPop)
+	c.parsePrecedence(And)
+	c.logicalOperand(operator)
 
-	patchJump(endJump)
+	c.patchJump(endJump)
 }
 
-func or_(canAssign bool) {
-	elseJump := emitJump(OpJumpIfFalse)
-	endJump := emitJump(OpJump)
+func (c *Compiler) or_(canAssign bool) {
+	operator := c.parser.previous
+	c.checkBoolean(operator, c.popType())
+	elseJump := c.emitJump(OpJumpIfFalse)
+	endJump := c.emitJump(OpJump)
 
-	patchJump(elseJump)
-	emitByte(OpPop)
+	c.patchJump(elseJump)
+	c.emitByte(OpPop)
 
-	parsePrecedence(Or)
-	patchJump(endJump)
+	c.parsePrecedence(Or)
+	c.logicalOperand(operator)
+	c.patchJump(endJump)
+}
+
+// logicalOperand checks the right operand of et and ou, which is the value
+// of the whole expression when it is reached. The left one is checked at
+// run time by OpJumpIfFalse; an untyped right one is checked by negating it
+// twice.
+func (c *Compiler) logicalOperand(operator *scanner.Token) {
+	right := c.popType()
+	c.checkBoolean(operator, right)
+	if right.IsAny() {
+		c.emitBytes(OpNot, OpNot)
+	}
+	c.pushType(chunk.BoolType)
 }
 
 // Emit Bytes
 
-func emitByte(by byte) {
-	currentChunk().WriteChunk(by, *parser.scanner.GetPosition(parser.previous.Position))
+func (c *Compiler) emitByte(by byte) {
+	c.currentChunk().WriteChunk(by, *c.parser.scanner.GetPosition(c.parser.previous.Position))
 }
 
-func emitBytes(by, by2 byte) {
-	emitByte(by)
-	emitByte(by2)
+func (c *Compiler) emitBytes(by, by2 byte) {
+	c.emitByte(by)
+	c.emitByte(by2)
 }
 
-func emitJump(instruction byte) int {
-	emitByte(instruction)
-	emitByte(0xff)
-	emitByte(0xff)
-	return len(currentChunk().Code) - 2
+func (c *Compiler) emitJump(instruction byte) int {
+	c.emitByte(instruction)
+	c.emitByte(0xff)
+	c.emitByte(0xff)
+	return len(c.currentChunk().Code) - 2
 }
 
-func emitConstant(value chunk.Value) {
-	emitBytes(OpConstant, makeConstant(value))
+func (c *Compiler) emitConstant(value chunk.Value) {
+	c.emitOperand(OpConstant, c.makeConstant(value))
 }
 
-func emitLoop(loopStart uint16) {
-	emitByte(OpLoop)
+// emitOperand emits the instruction op with its operand, a slot or the
+// index of a constant. An index past 255 uses the long form of op, whose
+// operand is on three bytes.
+func (c *Compiler) emitOperand(op byte, operand int) {
+	if operand <= math.MaxUint8 {
+		c.emitBytes(op, byte(operand))
+		return
+	}
+	c.emitByte(longForms[op])
+	c.emitByte(byte(operand >> 16))
+	c.emitByte(byte(operand >> 8))
+	c.emitByte(byte(operand))
+}
+
+func (c *Compiler) emitLoop(loopStart int) {
+	c.emitByte(OpLoop)
 
-	offset := uint16(len(currentChunk().Code)) - loopStart + 2
+	offset := len(c.currentChunk().Code) - loopStart + 2
 	if offset > math.MaxUint16 {
-		error("Loop body too large.")
+		c.error("Loop body too large.")
 	}
 
-	emitByte(byte((offset >> 8) & 0xff))
-	emitByte(byte(offset & 0xff))
+	c.emitByte(byte((offset >> 8) & 0xff))
+	c.emitByte(byte(offset & 0xff))
 }
 
-func patchJump(offset int) {
-	jump := len(currentChunk().Code) - offset - 2
+func (c *Compiler) patchJump(offset int) {
+	jump := len(c.currentChunk().Code) - offset - 2
 	if jump > math.MaxUint16 {
-		error("Too much code to jump over.")
+		c.error("Too much code to jump over.")
 	}
 
-	currentChunk().Code[offset] = byte((jump >> 8) & 0xff)
-	currentChunk().Code[offset+1] = byte(jump & 0xff)
+	c.currentChunk().Code[offset] = byte((jump >> 8) & 0xff)
+	c.currentChunk().Code[offset+1] = byte(jump & 0xff)
 }
 
-func emitReturn() {
-	emitByte(OpNull)
-	emitByte(OpReturn)
+// emitZeroValue pushes the value of a variable declared without one: 0,
+// 0.0, "" or faux for the basic types, nul otherwise.
+func (c *Compiler) emitZeroValue(t *chunk.Type) {
+	switch t.Kind {
+	case chunk.TypeInteger:
+		c.emitConstant(chunk.Value{Type: chunk.TypeInteger, Value: int64(0)})
+	case chunk.TypeFloat:
+		c.emitConstant(chunk.Value{Type: chunk.TypeFloat, Value: 0.0})
+	case chunk.TypeString:
+		c.emitConstant(chunk.Value{Type: chunk.TypeString, Value: chunk.NewGString("")})
+	case chunk.TypeBool:
+		c.emitByte(OpFalse)
+	default:
+		c.emitByte(OpNull)
+	}
+}
+
+func (c *Compiler) emitReturn() {
+	if c.current.Type == TypeInitializer {
+		c.emitBytes(OpGetLocal, 0)
+	} else {
+		c.emitByte(OpNull)
+	}
+	c.emitByte(OpReturn)
 }
 
 // Compiling Chunk
 
-func currentChunk() *chunk.Chunk {
-	return &current.Function.Chunk
+func (c *Compiler) currentChunk() *chunk.Chunk {
+	return &c.current.Function.Chunk
 }
 
 // Precedence
 
-func parsePrecedence(precedence Precedence) {
-	advance()
-	prefixRule := getRule(parser.previous.Token).prefix
+func (c *Compiler) parsePrecedence(precedence Precedence) {
+	c.advance()
+	prefixRule := getRule(c.parser.previous.Token).prefix
 	if prefixRule == nil {
-		error("Expect expression.")
+		c.error("Expect expression.")
 		return
 	}
 
 	canAssign := precedence <= token.PrecAssignment
-	prefixRule(canAssign)
+	start := len(c.currentChunk().Code)
+	prefixRule(c, canAssign)
 
-	for precedence <= getRule(parser.current.Token).precedence {
-		advance()
-		infixRule := getRule(parser.previous.Token).infix
+	for precedence <= getRule(c.parser.current.Token).precedence {
+		c.advance()
+		infixRule := getRule(c.parser.previous.Token).infix
 
-		infixRule(canAssign)
+		c.leftStart = start
+		infixRule(c, canAssign)
 	}
 
-	if canAssign && match(token.Equal) {
-		error("Invalid assignment target.")
+	if canAssign && c.match(token.Equal) {
+		c.error("Invalid assignment target.")
 	}
 }
 
-func identifierConstant(tk *scanner.Token) byte {
-	return makeConstant(chunk.Value{
+func (c *Compiler) identifierConstant(tk *scanner.Token) int {
+	return c.makeConstant(chunk.Value{
 		Type:  chunk.TypeString,
 		Value: chunk.NewGString(tk.LitName),
 	})
 }
 
 func identifiersEqual(a, b *scanner.Token) bool {
-	return a.LitName == b.LitName
+	return a.Token == b.Token && a.LitName == b.LitName
 }
 
-func resolveLocal(compiler *Compiler, tk *scanner.Token) int {
+func resolveLocal(compiler *FunctionCompiler, tk *scanner.Token) int {
 	for i := compiler.LocalCount - 1; i >= 0; i-- {
 		local := &compiler.Locals[i]
 		if identifiersEqual(tk, local.Name) {
@@ -615,107 +1336,171 @@ func resolveLocal(compiler *Compiler, tk *scanner.Token) int {
 	return -1
 }
 
-func addLocal(tk *scanner.Token) {
-	if current.LocalCount == math.MaxUint8+1 {
-		error("Too many local variables in function.")
+// resolveUpvalue looks for tk in the functions enclosing compiler, and
+// returns the index of the upvalue through which compiler reaches it.
+func (c *Compiler) resolveUpvalue(compiler *FunctionCompiler, tk *scanner.Token) int {
+	if compiler.Enclosing == nil {
+		return -1
+	}
+
+	if local := resolveLocal(compiler.Enclosing, tk); local != -1 {
+		compiler.Enclosing.Locals[local].IsCaptured = true
+		return c.addUpvalue(compiler, byte(local), true, compiler.Enclosing.Locals[local].Type)
+	}
+
+	if upvalue := c.resolveUpvalue(compiler.Enclosing, tk); upvalue != -1 {
+		return c.addUpvalue(compiler, byte(upvalue), false, compiler.Enclosing.Upvalues[upvalue].Type)
+	}
+
+	return -1
+}
+
+func (c *Compiler) addUpvalue(compiler *FunctionCompiler, index byte, isLocal bool, t *chunk.Type) int {
+	for i, upvalue := range compiler.Upvalues {
+		if upvalue.Index == index && upvalue.IsLocal == isLocal {
+			return i
+		}
+	}
+
+	if len(compiler.Upvalues) == math.MaxUint8+1 {
+		c.error("Too many closure variables in function.")
+		return 0
+	}
+
+	if t == nil {
+		t = chunk.AnyType
+	}
+	compiler.Upvalues = append(compiler.Upvalues, Upvalue{Index: index, IsLocal: isLocal, Type: t})
+	compiler.Function.UpvalueCount = len(compiler.Upvalues)
+	return len(compiler.Upvalues) - 1
+}
+
+func (c *Compiler) addLocal(tk *scanner.Token) {
+	if c.current.LocalCount == math.MaxUint8+1 {
+		c.error("Too many local variables in function.")
 		return
 	}
-	local := &current.Locals[current.LocalCount]
-	current.LocalCount++
+	local := &c.current.Locals[c.current.LocalCount]
+	c.current.LocalCount++
 	local.Name = tk
 	local.Depth = -1
+	local.IsCaptured = false
 }
 
-func parseVariable(errorMessage string) byte {
-	consume(token.Identifier, errorMessage)
+func (c *Compiler) parseVariable(errorMessage string) int {
+	c.consume(token.Identifier, errorMessage)
 
-	declareVariable()
-	if current.ScoreDepth > 0 {
+	c.declareVariable()
+	if c.current.ScoreDepth > 0 {
 		return 0
 	}
-	return identifierConstant(parser.previous)
+	return c.identifierConstant(c.parser.previous)
 }
 
-func markInitialized() {
-	if current.ScoreDepth == 0 {
+func (c *Compiler) markInitialized() {
+	if c.current.ScoreDepth == 0 {
 		return
 	}
-	current.Locals[current.LocalCount-1].Depth = current.ScoreDepth
+	c.current.Locals[c.current.LocalCount-1].Depth = c.current.ScoreDepth
 }
 
-func defineVariable(global byte) {
-	if current.ScoreDepth > 0 {
-		markInitialized()
+func (c *Compiler) defineVariable(global int) {
+	if c.current.ScoreDepth > 0 {
+		c.markInitialized()
 		return
 	}
-	emitBytes(OpDefineGlobal, global)
+	c.emitOperand(OpDefineGlobal, global)
 }
 
-func argumentList() byte {
-	var argCount byte = 0
+func (c *Compiler) argumentList() []*chunk.Type {
+	var args []*chunk.Type
 	var firstRound = true
-	if !check(token.RParentheses) {
-		for firstRound || match(token.Comma) {
+	if !c.check(token.RParentheses) {
+		for firstRound || c.match(token.Comma) {
 			firstRound = false
-			expression()
-			if argCount == 255 {
-				error("Can't have more than 255 arguments.")
+			c.expression()
+			if len(args) == 255 {
+				c.error("Can't have more than 255 arguments.")
 			}
-			argCount++
+			args = append(args, c.popType())
 		}
 	}
 
-	consume(token.RParentheses, "Expect ')' after arguments.")
-	return argCount
+	c.consume(token.RParentheses, "Expect ')' after arguments.")
+	return args
 }
 
-func declareVariable() {
-	if current.ScoreDepth == 0 {
+func (c *Compiler) declareVariable() {
+	if c.current.ScoreDepth == 0 {
 		return
 	}
-	tk := parser.previous
+	tk := c.parser.previous
 
-	for i := current.LocalCount - 1; i >= 0; i-- {
-		local := &current.Locals[i]
-		if local.Depth != -1 && local.Depth < current.ScoreDepth {
+	for i := c.current.LocalCount - 1; i >= 0; i-- {
+		local := &c.current.Locals[i]
+		if local.Depth != -1 && local.Depth < c.current.ScoreDepth {
 			break
 		}
 
 		if identifiersEqual(tk, local.Name) {
-			error("Already variable with this name in this scope.")
+			if d := c.error("Already variable with this name in this scope."); d != nil {
+				d.Notes = append(d.Notes, Note{
+					Span:    c.span(local.Name),
+					Message: fmt.Sprintf("previous declaration of %s", local.Name.LitName),
+				})
+			}
 		}
 	}
 
-	addLocal(tk)
+	c.addLocal(tk)
 }
 
 // Error Handlers
 
-func errorAt(tk *scanner.Token, message string) {
-	if parser.panicMode {
-		return
+// span returns the span of the token tk.
+func (c *Compiler) span(tk *scanner.Token) Span {
+	start := *c.parser.scanner.GetPosition(tk.Position)
+	if tk.End <= tk.Position {
+		return Span{Start: start, End: start}
 	}
-	parser.panicMode = true
+	return Span{Start: start, End: *c.parser.scanner.GetPosition(tk.End)}
+}
 
-	pos := parser.scanner.GetPosition(tk.Position)
-	fmt.Fprintf(os.Stderr, "[line %d:%d] Error", pos.Line, pos.Column)
+func (c *Compiler) report(d *Diagnostic) *Diagnostic {
+	c.parser.diagnostics = append(c.parser.diagnostics, d)
+	return d
+}
 
-	if tk.Token == token.Eof {
-		fmt.Fprintf(os.Stderr, " at end")
-	} else if tk.Token == token.Error {
+func (c *Compiler) scanError(pos gotoken.Position, message string) {
+	start := token.GoTokenPosToPos(pos)
+	c.report(&Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeScan,
+		Message:  message,
+		Span:     Span{Start: start, End: start},
+	})
+}
 
-	} else {
-		fmt.Fprintf(os.Stderr, " at '%s'", tk.LitName)
+// errorAt reports a syntax error at tk and returns it, so that notes can
+// be added to it, or nil in panic mode.
+func (c *Compiler) errorAt(tk *scanner.Token, message string) *Diagnostic {
+	if c.parser.panicMode {
+		return nil
 	}
+	c.parser.panicMode = true
 
-	fmt.Fprintf(os.Stderr, ": %s\n", message)
-	parser.hadError = true
+	return c.report(&Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeSyntax,
+		Message:  message,
+		Span:     c.span(tk),
+	})
 }
 
-func error(message string) {
-	errorAt(parser.previous, message)
+func (c *Compiler) error(message string) *Diagnostic {
+	return c.errorAt(c.parser.previous, message)
 }
 
-func errorAtCurrent(message string) {
-	errorAt(parser.current, message)
+func (c *Compiler) errorAtCurrent(message string) *Diagnostic {
+	return c.errorAt(c.parser.current, message)
 }
@@ -0,0 +1,37 @@
+package compiler
+
+import (
+	"GNBS/token"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentCompile(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			opts, src := Options{}, fmt.Sprintf("fonction f%d(n: Ent) -> Ent {\n\trevenir n * %d\n}\nvar x = f%d(2)\n", i, i, i)
+			if i%2 == 1 {
+				opts.Locale = token.English
+				src = fmt.Sprintf("function f%d(n: Int) -> Int {\n\treturn n * %d\n}\nvar x = f%d(2)\n", i, i, i)
+			}
+
+			c := New(opts)
+			for j := 0; j < 10; j++ {
+				fn, _ := c.Compile([]byte(src))
+				if fn == nil {
+					t.Errorf("%q did not compile", src)
+					return
+				}
+				if got := fn.Chunk.Values[1].FunctionName(); got != fmt.Sprintf("f%d", i) {
+					t.Errorf("compiled %s instead of f%d", got, i)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -14,7 +14,8 @@ func DisassembleChunk(c *chunk.Chunk, name string) {
 
 func DisassembleInstruction(c *chunk.Chunk, offset int) int {
 	fmt.Printf("%04d ", offset)
-	fmt.Printf("%s ", c.Pos[offset].String())
+	pos := c.Position(offset)
+	fmt.Printf("%s ", pos.String())
 
 	instruction := c.Code[offset]
 	switch instruction {
@@ -34,6 +35,12 @@ func DisassembleInstruction(c *chunk.Chunk, offset int) int {
 		return simpleInstruction("OP_DIVIDE", offset)
 	case OpMultiply:
 		return simpleInstruction("OP_MULTIPLY", offset)
+	case OpModulo:
+		return simpleInstruction("OP_MODULO", offset)
+	case OpFloorDivide:
+		return simpleInstruction("OP_FLOOR_DIVIDE", offset)
+	case OpPower:
+		return simpleInstruction("OP_POWER", offset)
 	case OpNull:
 		return simpleInstruction("OP_NULL", offset)
 	case OpTrue:
@@ -58,6 +65,8 @@ func DisassembleInstruction(c *chunk.Chunk, offset int) int {
 		return byteInstruction("OP_SET_LOCAL", c, offset)
 	case OpGetGlobal:
 		return constantInstruction("OP_GET_GLOBAL", c, offset)
+	case OpSetGlobal:
+		return constantInstruction("OP_SET_GLOBAL", c, offset)
 	case OpJump:
 		return jumpInstruction("OP_JUMP", 1, c, offset)
 	case OpJumpIfFalse:
@@ -66,6 +75,50 @@ func DisassembleInstruction(c *chunk.Chunk, offset int) int {
 		return jumpInstruction("OP_LOOP", -1, c, offset)
 	case OpCall:
 		return byteInstruction("OP_CALL", c, offset)
+	case OpClass:
+		return constantInstruction("OP_CLASS", c, offset)
+	case OpField:
+		return constantInstruction("OP_FIELD", c, offset)
+	case OpMethod:
+		return constantInstruction("OP_METHOD", c, offset)
+	case OpGetProperty:
+		return constantInstruction("OP_GET_PROPERTY", c, offset)
+	case OpSetProperty:
+		return constantInstruction("OP_SET_PROPERTY", c, offset)
+	case OpInherit:
+		return simpleInstruction("OP_INHERIT", offset)
+	case OpGetSuper:
+		return constantInstruction("OP_GET_SUPER", c, offset)
+	case OpClosure:
+		return closureInstruction("OP_CLOSURE", c, offset)
+	case OpGetUpvalue:
+		return byteInstruction("OP_GET_UPVALUE", c, offset)
+	case OpSetUpvalue:
+		return byteInstruction("OP_SET_UPVALUE", c, offset)
+	case OpCloseUpvalue:
+		return simpleInstruction("OP_CLOSE_UPVALUE", offset)
+	case OpConstantLong:
+		return constantInstruction("OP_CONSTANT_LONG", c, offset)
+	case OpDefineGlobalLong:
+		return constantInstruction("OP_DEFINE_GLOBAL_LONG", c, offset)
+	case OpGetGlobalLong:
+		return constantInstruction("OP_GET_GLOBAL_LONG", c, offset)
+	case OpSetGlobalLong:
+		return constantInstruction("OP_SET_GLOBAL_LONG", c, offset)
+	case OpClassLong:
+		return constantInstruction("OP_CLASS_LONG", c, offset)
+	case OpFieldLong:
+		return constantInstruction("OP_FIELD_LONG", c, offset)
+	case OpMethodLong:
+		return constantInstruction("OP_METHOD_LONG", c, offset)
+	case OpGetPropertyLong:
+		return constantInstruction("OP_GET_PROPERTY_LONG", c, offset)
+	case OpSetPropertyLong:
+		return constantInstruction("OP_SET_PROPERTY_LONG", c, offset)
+	case OpGetSuperLong:
+		return constantInstruction("OP_GET_SUPER_LONG", c, offset)
+	case OpClosureLong:
+		return closureInstruction("OP_CLOSURE_LONG", c, offset)
 	default:
 		fmt.Printf("Unknown OpCode %d\n", instruction)
 		return offset + 1
@@ -78,12 +131,43 @@ func simpleInstruction(name string, offset int) int {
 }
 
 func constantInstruction(name string, c *chunk.Chunk, offset int) int {
-	constant := c.Code[offset+1]
+	constant, offset := constantOperand(c, offset)
 
 	fmt.Printf("%-16s %4d '", name, constant)
 	chunk.PrintValue(c.Values[constant])
 	fmt.Printf("'\n")
-	return offset + 2
+	return offset
+}
+
+// constantOperand returns the index of the constant used by the
+// instruction at offset, on three bytes for a long instruction, and the
+// offset that follows it.
+func constantOperand(c *chunk.Chunk, offset int) (int, int) {
+	if !isLong(c.Code[offset]) {
+		return int(c.Code[offset+1]), offset + 2
+	}
+	return int(c.Code[offset+1])<<16 | int(c.Code[offset+2])<<8 | int(c.Code[offset+3]), offset + 4
+}
+
+// closureInstruction prints OpClosure and the pair of bytes that follows it
+// for each upvalue of the function.
+func closureInstruction(name string, c *chunk.Chunk, offset int) int {
+	constant, offset := constantOperand(c, offset)
+
+	fmt.Printf("%-16s %4d ", name, constant)
+	chunk.PrintValue(c.Values[constant])
+	fmt.Println()
+
+	fn, _ := c.Values[constant].Value.(*chunk.GFunction)
+	for i := 0; i < fn.UpvalueCount; i++ {
+		kind := "upvalue"
+		if c.Code[offset] == 1 {
+			kind = "local"
+		}
+		fmt.Printf("%04d      |                     %s %d\n", offset, kind, c.Code[offset+1])
+		offset += 2
+	}
+	return offset
 }
 
 func byteInstruction(name string, c *chunk.Chunk, offset int) int {
@@ -92,10 +176,10 @@ func byteInstruction(name string, c *chunk.Chunk, offset int) int {
 	return offset + 2
 }
 
-func jumpInstruction(name string, sign uint16, c *chunk.Chunk, offset int) int {
-	jump := uint16(c.Code[offset + 1]) << 8
-	jump |= uint16(c.Code[offset + 2])
+func jumpInstruction(name string, sign int, c *chunk.Chunk, offset int) int {
+	jump := int(c.Code[offset+1]) << 8
+	jump |= int(c.Code[offset+2])
 
-	fmt.Printf("%-16s %4d -> %d\n", name, offset, uint16(offset) + 3 + sign * jump)
+	fmt.Printf("%-16s %4d -> %d\n", name, offset, offset+3+sign*jump)
 	return offset + 3
 }
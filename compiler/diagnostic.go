@@ -0,0 +1,197 @@
+package compiler
+
+import (
+	"GNBS/token"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity tells how serious a Diagnostic is. Only errors stop a source
+// from compiling.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+var severities = [...]string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+}
+
+func (s Severity) String() string {
+	if 0 <= s && int(s) < len(severities) {
+		return severities[s]
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// The codes of the diagnostics, one per kind of check.
+const (
+	// CodeScan is a malformed token, reported by the scanner.
+	CodeScan = "E001"
+	// CodeSyntax is a source that doesn't follow the grammar, or a
+	// construct used where it isn't allowed.
+	CodeSyntax = "E002"
+	// CodeType is an error of the type checker.
+	CodeType = "E003"
+)
+
+// Span is the part of a source a diagnostic is about, End excluded. End
+// equals Start for a point, such as the end of the file.
+type Span struct {
+	Start token.Position
+	End   token.Position
+}
+
+// Note is a remark attached to a diagnostic, about another part of the
+// source.
+type Note struct {
+	Span    Span
+	Message string
+}
+
+// Diagnostic is a problem found while compiling a source.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Span     Span
+	Notes    []Note
+}
+
+// Error returns the position and message of d on one line.
+func (d *Diagnostic) Error() string {
+	severity := d.Severity.String()
+	return fmt.Sprintf("[%s] %s: %s", positionString(d.Span.Start), strings.ToUpper(severity[:1])+severity[1:], d.Message)
+}
+
+func positionString(pos token.Position) string {
+	if pos.Filename == "" {
+		return fmt.Sprintf("line %d:%d", pos.Line, pos.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", pos.Filename, pos.Line, pos.Column)
+}
+
+// Diagnostics are all the problems found in a source, in the order they
+// were found. It is the error Interpret returns for a source that doesn't
+// compile.
+type Diagnostics []*Diagnostic
+
+// HasErrors reports whether any of the diagnostics is an error.
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Error returns the diagnostics one per line.
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FprintDiagnostics writes ds to w for a human reader: each one with the
+// line of source it is about, the span underlined with carets, followed
+// by its notes.
+func FprintDiagnostics(w io.Writer, ds Diagnostics, source []byte) {
+	for _, d := range ds {
+		fmt.Fprintf(w, "%s: %s[%s]: %s\n", positionString(d.Span.Start), d.Severity, d.Code, d.Message)
+		fprintSnippet(w, d.Span, source)
+		for _, note := range d.Notes {
+			fmt.Fprintf(w, "%s: note: %s\n", positionString(note.Span.Start), note.Message)
+			fprintSnippet(w, note.Span, source)
+		}
+	}
+}
+
+// jsonDiagnostic is the JSON record of a Diagnostic or a Note.
+type jsonDiagnostic struct {
+	File      string           `json:"file"`
+	Line      int              `json:"line"`
+	Column    int              `json:"column"`
+	EndLine   int              `json:"endLine"`
+	EndColumn int              `json:"endColumn"`
+	Severity  string           `json:"severity"`
+	Code      string           `json:"code,omitempty"`
+	Message   string           `json:"message"`
+	Notes     []jsonDiagnostic `json:"notes,omitempty"`
+}
+
+func newJSONDiagnostic(span Span, severity, code, message string) jsonDiagnostic {
+	return jsonDiagnostic{
+		File:      span.Start.Filename,
+		Line:      span.Start.Line,
+		Column:    span.Start.Column,
+		EndLine:   span.End.Line,
+		EndColumn: span.End.Column,
+		Severity:  severity,
+		Code:      code,
+		Message:   message,
+	}
+}
+
+// FprintDiagnosticsJSON writes ds to w as JSON, one object per line with
+// the file, start and end positions, severity, code and message of the
+// diagnostic, and its notes.
+func FprintDiagnosticsJSON(w io.Writer, ds Diagnostics) error {
+	encoder := json.NewEncoder(w)
+	for _, d := range ds {
+		record := newJSONDiagnostic(d.Span, d.Severity.String(), d.Code, d.Message)
+		for _, note := range d.Notes {
+			record.Notes = append(record.Notes, newJSONDiagnostic(note.Span, "note", "", note.Message))
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fprintSnippet writes the first line of span with carets under it.
+func fprintSnippet(w io.Writer, span Span, source []byte) {
+	start := span.Start.Offset
+	if span.Start.Line <= 0 || start > len(source) {
+		return
+	}
+	lineStart := bytes.LastIndexByte(source[:start], '\n') + 1
+	lineEnd := len(source)
+	if i := bytes.IndexByte(source[start:], '\n'); i >= 0 {
+		lineEnd = start + i
+	}
+	end := span.End.Offset
+	if end > lineEnd {
+		end = lineEnd
+	}
+
+	line := string(source[lineStart:lineEnd])
+	gutter := fmt.Sprintf("%d", span.Start.Line)
+	fmt.Fprintf(w, "%s | %s\n", gutter, line)
+
+	// Keep the tabs of the line so that the carets line up with it.
+	var underline strings.Builder
+	for _, r := range string(source[lineStart:start]) {
+		if r == '\t' {
+			underline.WriteRune('\t')
+		} else {
+			underline.WriteRune(' ')
+		}
+	}
+	width := 1
+	if end > start {
+		width = utf8.RuneCount(source[start:end])
+	}
+	underline.WriteString(strings.Repeat("^", width))
+	fmt.Fprintf(w, "%s | %s\n", strings.Repeat(" ", len(gutter)), underline.String())
+}
@@ -0,0 +1,121 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	src := `var b: Ent = "b"
+fonction f( {
+}
+var c = "c
+afficher b c
+var a = 1 +
+`
+	fn, diagnostics := New(Options{Filename: "test.gnbs"}).Compile([]byte(src))
+	if fn != nil {
+		t.Fatal("compiled a source with errors")
+	}
+
+	want := []struct {
+		code      string
+		line, col int
+		endCol    int
+		message   string
+	}{
+		{CodeType, 1, 5, 6, "cannot use Cha as Ent in declaration of b"},
+		{CodeSyntax, 2, 13, 14, "Expect parameter name."},
+		{CodeScan, 4, 9, 9, "string literal not terminated"},
+		{CodeSyntax, 5, 12, 13, "Expect ';' after value."},
+		{CodeSyntax, 6, 13, 13, "Expect expression."},
+	}
+	if len(diagnostics) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d:\n%v", len(diagnostics), len(want), diagnostics)
+	}
+	for i, w := range want {
+		d := diagnostics[i]
+		if d.Severity != SeverityError || d.Code != w.code || d.Message != w.message ||
+			d.Span.Start.Line != w.line || d.Span.Start.Column != w.col || d.Span.End.Column != w.endCol {
+			t.Errorf("diagnostic %d: got %s %s %d:%d-%d %q, want %s %d:%d-%d %q", i,
+				d.Severity, d.Code, d.Span.Start.Line, d.Span.Start.Column, d.Span.End.Column, d.Message,
+				w.code, w.line, w.col, w.endCol, w.message)
+		}
+		if d.Span.Start.Filename != "test.gnbs" {
+			t.Errorf("diagnostic %d is in file %q", i, d.Span.Start.Filename)
+		}
+	}
+}
+
+func TestDiagnosticNotes(t *testing.T) {
+	_, diagnostics := Compile([]byte("fonction f() {\n\tvar x = 1\n\tvar x = 2\n}\n"))
+	if len(diagnostics) != 1 || len(diagnostics[0].Notes) != 1 {
+		t.Fatalf("got %v", diagnostics)
+	}
+	note := diagnostics[0].Notes[0]
+	if note.Message != "previous declaration of x" || note.Span.Start.Line != 2 || note.Span.Start.Column != 6 {
+		t.Errorf("got note %q at %d:%d", note.Message, note.Span.Start.Line, note.Span.Start.Column)
+	}
+}
+
+func TestFprintDiagnostics(t *testing.T) {
+	src := []byte("fonction f() {\n\tvar nom = 1\n\tvar nom = \"a\"\n}\nvar x: Ent = vrai\n")
+	_, diagnostics := New(Options{Filename: "a.gnbs"}).Compile(src)
+
+	var out bytes.Buffer
+	FprintDiagnostics(&out, diagnostics, src)
+	want := `a.gnbs:3:6: error[E002]: Already variable with this name in this scope.
+3 | 	var nom = "a"
+  | 	    ^^^
+a.gnbs:2:6: note: previous declaration of nom
+2 | 	var nom = 1
+  | 	    ^^^
+a.gnbs:5:5: error[E003]: cannot use Bool as Ent in declaration of x
+5 | var x: Ent = vrai
+  |     ^
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if got := diagnostics[1].Error(); got != "[a.gnbs:5:5] Error: cannot use Bool as Ent in declaration of x" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestFprintDiagnosticsJSON(t *testing.T) {
+	src := []byte("fonction f() {\n\tvar x = 1\n\tvar x = 2\n}\n")
+	_, diagnostics := New(Options{Filename: "a.gnbs"}).Compile(src)
+
+	var out bytes.Buffer
+	if err := FprintDiagnosticsJSON(&out, diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"file":"a.gnbs","line":3,"column":6,"endLine":3,"endColumn":7,"severity":"error","code":"E002",` +
+		`"message":"Already variable with this name in this scope.","notes":[{"file":"a.gnbs","line":2,"column":6,` +
+		`"endLine":2,"endColumn":7,"severity":"note","message":"previous declaration of x"}]}` + "\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestRuntimeErrorDiagnostic(t *testing.T) {
+	vm := NewVM(Options{Filename: "a.gnbs"})
+	_, err := vm.Interpret(context.Background(), []byte("fonction f(n: Ent) -> Ent {\n\trevenir 1 / n\n}\nafficher f(0)\n"))
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("got error %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := FprintDiagnosticsJSON(&out, Diagnostics{runtimeErr.Diagnostic()}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"file":"a.gnbs","line":2,"column":14,"endLine":2,"endColumn":14,"severity":"error","code":"E103",` +
+		`"message":"Division by zero.","notes":[{"file":"a.gnbs","line":4,"column":13,"endLine":4,"endColumn":13,` +
+		`"severity":"note","message":"called from script"}]}` + "\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
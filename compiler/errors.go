@@ -0,0 +1,103 @@
+package compiler
+
+import (
+	"GNBS/token"
+	"fmt"
+	"strings"
+)
+
+// ErrorKind classifies runtime errors.
+type ErrorKind int
+
+const (
+	// KindType is a value of the wrong type: an operand, an argument, a
+	// callee or a superclass.
+	KindType ErrorKind = iota
+	// KindName is an undefined variable, field or method.
+	KindName
+	// KindArity is a call with the wrong number of arguments.
+	KindArity
+	KindDivisionByZero
+	KindStackOverflow
+	// KindNative is an error returned by a native function.
+	KindNative
+	// KindInterrupted is a program stopped by its context.
+	KindInterrupted
+)
+
+var errorKinds = [...]string{
+	KindType:           "type error",
+	KindName:           "name error",
+	KindArity:          "arity error",
+	KindDivisionByZero: "division by zero",
+	KindStackOverflow:  "stack overflow",
+	KindNative:         "native error",
+	KindInterrupted:    "interrupted",
+}
+
+// Code returns the diagnostic code of errors of kind k, which follow the
+// codes of the compiler from E100 on.
+func (k ErrorKind) Code() string {
+	return fmt.Sprintf("E%03d", 100+int(k))
+}
+
+func (k ErrorKind) String() string {
+	if 0 <= k && int(k) < len(errorKinds) {
+		return errorKinds[k]
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// StackFrame is one call active when a runtime error occurred, with the
+// position of the instruction it was running.
+type StackFrame struct {
+	// Function is the name of the function, "script" for the top level.
+	Function string
+	Pos      token.Position
+}
+
+func (f StackFrame) String() string {
+	return fmt.Sprintf("[%s] in %s", positionString(f.Pos), f.Function)
+}
+
+// RuntimeError is the error of a GNBS program that stopped while running.
+// Frames lists the active calls, innermost first.
+type RuntimeError struct {
+	Kind    ErrorKind
+	Message string
+	Frames  []StackFrame
+}
+
+// Error returns the message followed by the stack trace, one frame per
+// line.
+func (e *RuntimeError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	for _, frame := range e.Frames {
+		b.WriteString("\n")
+		b.WriteString(frame.String())
+	}
+	return b.String()
+}
+
+// Diagnostic returns e as a diagnostic at the instruction that failed,
+// with a note for each call that led to it.
+func (e *RuntimeError) Diagnostic() *Diagnostic {
+	d := &Diagnostic{
+		Severity: SeverityError,
+		Code:     e.Kind.Code(),
+		Message:  e.Message,
+	}
+	for i, frame := range e.Frames {
+		span := Span{Start: frame.Pos, End: frame.Pos}
+		if i == 0 {
+			d.Span = span
+			continue
+		}
+		d.Notes = append(d.Notes, Note{
+			Span:    span,
+			Message: "called from " + frame.Function,
+		})
+	}
+	return d
+}
@@ -0,0 +1,95 @@
+package compiler
+
+import (
+	"GNBS/chunk"
+	"GNBS/token"
+)
+
+// emitOperator emits ops, the instructions of an operator whose operands
+// start at offset start in the code. When the operands are all constants,
+// the result is computed now and emitted as a constant instead. An
+// operation that fails, such as a division by zero, is left for the VM to
+// report at run time.
+//
+// Operations with a single constant operand, such as x + 0 or x * 1, are
+// kept: a variable declared Ent can hold any value assigned from an
+// untyped expression, and only the VM checks that x is really an Ent.
+func (c *Compiler) emitOperator(start int, ops ...byte) {
+	if !c.opts.NoOptimize {
+		operands, indexes := c.constantOperands(start)
+		if len(operands) == instructions[ops[0]].pops {
+			if result, ok := c.fold(operands, ops); ok {
+				c.replaceOperands(start, indexes, result)
+				return
+			}
+		}
+	}
+	for _, op := range ops {
+		c.emitByte(op)
+	}
+}
+
+// constantOperands returns the constants pushed by the code from start to
+// the end, and the indexes of those in the constants of the chunk, or nil
+// if the code does anything else.
+func (c *Compiler) constantOperands(start int) (operands []chunk.Value, indexes []int) {
+	ch := c.currentChunk()
+	for offset := start; offset < len(ch.Code); {
+		switch op := ch.Code[offset]; op {
+		case OpTrue, OpFalse:
+			operands = append(operands, chunk.Value{Type: chunk.TypeBool, Value: op == OpTrue})
+			offset++
+		case OpConstant, OpConstantLong:
+			index, next := constantOperand(ch, offset)
+			operands = append(operands, ch.Values[index])
+			indexes = append(indexes, index)
+			offset = next
+		default:
+			return nil, nil
+		}
+	}
+	return operands, indexes
+}
+
+// fold runs the instructions ops on the constants operands, as the VM
+// does, and returns their result, or false if they fail.
+func (c *Compiler) fold(operands []chunk.Value, ops []byte) (chunk.Value, bool) {
+	fn := chunk.NewGFunction()
+	for i, operand := range operands {
+		fn.Chunk.Values = append(fn.Chunk.Values, operand)
+		fn.Chunk.WriteChunk(OpConstant, token.Position{})
+		fn.Chunk.WriteChunk(byte(i), token.Position{})
+	}
+	for _, op := range ops {
+		fn.Chunk.WriteChunk(op, token.Position{})
+	}
+	fn.Chunk.WriteChunk(OpReturn, token.Position{})
+
+	if c.folder == nil {
+		c.folder = &VM{
+			Frames: make([]CallFrame, 1),
+			stack:  make([]chunk.Value, 1+instructions[OpAdd].pops),
+		}
+	}
+	return c.folder.evaluate(fn)
+}
+
+// replaceOperands removes the code from start, which pushes the constants
+// at indexes, and emits result in its place. The constants that are no
+// longer used are removed too when they are the last ones of the chunk.
+func (c *Compiler) replaceOperands(start int, indexes []int, result chunk.Value) {
+	ch := c.currentChunk()
+	ch.Truncate(start)
+	for i := len(indexes) - 1; i >= 0 && indexes[i] == len(ch.Values)-1; i-- {
+		ch.Values = ch.Values[:len(ch.Values)-1]
+	}
+
+	switch {
+	case result.Type != chunk.TypeBool:
+		c.emitConstant(result)
+	case result.Bool():
+		c.emitByte(OpTrue)
+	default:
+		c.emitByte(OpFalse)
+	}
+}
@@ -0,0 +1,94 @@
+package compiler
+
+import (
+	"GNBS/chunk"
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []struct {
+		src  string
+		code []byte
+		want *chunk.Value
+	}{
+		{"afficher 2 * 60 * 60\n", []byte{OpConstant, 0, OpPrint}, &chunk.Value{Type: chunk.TypeInteger, Value: int64(7200)}},
+		{"afficher (1 + 2) * -3\n", []byte{OpConstant, 0, OpPrint}, &chunk.Value{Type: chunk.TypeInteger, Value: int64(-9)}},
+		{"afficher 2 ** 3 ** 2\n", []byte{OpConstant, 0, OpPrint}, &chunk.Value{Type: chunk.TypeInteger, Value: int64(512)}},
+		{"afficher 7 div -2\n", []byte{OpConstant, 0, OpPrint}, &chunk.Value{Type: chunk.TypeInteger, Value: int64(-4)}},
+		{"afficher 9223372036854775807 + 1\n", []byte{OpConstant, 0, OpPrint}, &chunk.Value{Type: chunk.TypeInteger, Value: int64(-9223372036854775808)}},
+		{"afficher 1.5 * 2.0\n", []byte{OpConstant, 0, OpPrint}, &chunk.Value{Type: chunk.TypeFloat, Value: 3.0}},
+		{"afficher \"a\" + \"b\"\n", []byte{OpConstant, 0, OpPrint}, &chunk.Value{Type: chunk.TypeString, Value: chunk.NewGString("ab")}},
+		{"afficher 1 <= 2\n", []byte{OpTrue, OpPrint}, nil},
+		{"afficher np (1 == 1)\n", []byte{OpFalse, OpPrint}, nil},
+
+		// What fails at run time is left for the VM to report.
+		{"afficher 1 div 0\n", []byte{OpConstant, 0, OpConstant, 1, OpFloorDivide, OpPrint}, nil},
+		{"afficher 1.0 / 0.0\n", []byte{OpConstant, 0, OpConstant, 1, OpDivide, OpPrint}, nil},
+		{"var x = 2\nafficher x * 3\n", []byte{OpConstant, 1, OpDefineGlobal, 0, OpGetGlobal, 2, OpConstant, 3, OpMultiply, OpPrint}, nil},
+		{"var x = 2\nafficher x + 0\n", []byte{OpConstant, 1, OpDefineGlobal, 0, OpGetGlobal, 2, OpConstant, 3, OpAdd, OpPrint}, nil},
+	}
+	for _, test := range tests {
+		fn, diagnostics := Compile([]byte(test.src))
+		if fn == nil {
+			t.Fatalf("%q: %v", test.src, diagnostics)
+		}
+		// Leave out the implicit return of the script.
+		code := fn.Chunk.Code[:len(fn.Chunk.Code)-2]
+		if !bytes.Equal(code, test.code) {
+			t.Errorf("%q: compiled to %v, want %v", test.src, code, test.code)
+			continue
+		}
+		if test.want != nil {
+			if len(fn.Chunk.Values) != 1 || !chunk.ValuesEqual(fn.Chunk.Values[0], *test.want) {
+				t.Errorf("%q: got constants %v, want %v", test.src, fn.Chunk.Values, test.want.Value)
+			}
+		}
+	}
+}
+
+// TestNoSimplification checks that x + 0 still fails when x, declared
+// Ent, holds a string at run time.
+func TestNoSimplification(t *testing.T) {
+	src := "fonction f(a) {\n\tvar x: Ent = a\n\tafficher x + 0\n}\nf(\"s\")\n"
+	_, err := NewVM(Options{}).Interpret(context.Background(), []byte(src))
+	if runtimeErr, ok := err.(*RuntimeError); !ok || runtimeErr.Kind != KindType {
+		t.Errorf("got error %v, want a type error", err)
+	}
+}
+
+func TestNoOptimize(t *testing.T) {
+	src := []byte(`afficher 2 * 60 * 60
+afficher "x" + "y"
+afficher -2 ** 2
+afficher 2.0 / 0.0
+afficher 7 mod -2 == -1
+pendant i := 0; i < 2 + 1; i++ {
+	afficher i * (10 - 1)
+}
+afficher 1 div (1 - 1)
+`)
+
+	folded, _ := New(Options{}).Compile(src)
+	unfolded, _ := New(Options{NoOptimize: true}).Compile(src)
+	if len(folded.Chunk.Code) >= len(unfolded.Chunk.Code) {
+		t.Errorf("folded to %d bytes, %d without folding", len(folded.Chunk.Code), len(unfolded.Chunk.Code))
+	}
+
+	var outputs [2]bytes.Buffer
+	var errors [2]*RuntimeError
+	for i, fn := range []*chunk.GFunction{folded, unfolded} {
+		vm := NewVM(Options{Stdout: &outputs[i]})
+		if _, err := vm.Run(context.Background(), fn); err != nil {
+			errors[i], _ = err.(*RuntimeError)
+		}
+	}
+	if outputs[0].String() != outputs[1].String() {
+		t.Errorf("printed %q with folding, %q without", outputs[0].String(), outputs[1].String())
+	}
+	if errors[0] == nil || !reflect.DeepEqual(errors[0], errors[1]) {
+		t.Errorf("got error %v with folding, %v without", errors[0], errors[1])
+	}
+}
@@ -5,4 +5,6 @@ type FunctionType int
 const (
 	TypeFunction FunctionType = iota
 	TypeScript
+	TypeMethod
+	TypeInitializer
 )
@@ -0,0 +1,151 @@
+package compiler
+
+import (
+	"GNBS/chunk"
+	"context"
+	"fmt"
+)
+
+// NativeFunc is a Go function that GNBS code can call. Its arguments are
+// converted to Go values: int64 for Ent, float64 for Flot, string for Cha,
+// bool for Bool and nil for nul; any other value is passed as a
+// chunk.Value. The result is converted back the same way, int included. A
+// returned error stops the program with a runtime error.
+type NativeFunc func(args ...interface{}) (interface{}, error)
+
+// RegisterNative defines the global name as a native function with the
+// given parameter and return types. Calls to it are checked like calls to
+// GNBS functions, when compiling and when running. A nil type, for ret or
+// in params, stands for chunk.AnyType.
+func (vm *VM) RegisterNative(name string, params []*chunk.Type, ret *chunk.Type, fn NativeFunc) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	params = append([]*chunk.Type(nil), params...)
+	for i, param := range params {
+		if param == nil {
+			params[i] = chunk.AnyType
+		}
+	}
+	if ret == nil {
+		ret = chunk.AnyType
+	}
+
+	native := chunk.NewGNative(name, params, ret, func(args []chunk.Value) (chunk.Value, error) {
+		goArgs := make([]interface{}, len(args))
+		for i, arg := range args {
+			goArgs[i] = toGo(arg)
+		}
+
+		result, err := fn(goArgs...)
+		if err != nil {
+			return chunk.Value{}, err
+		}
+		value, err := fromGo(result)
+		if err != nil {
+			return chunk.Value{}, fmt.Errorf("%s() returned %v", name, err)
+		}
+		if !isOfType(value, ret) {
+			return chunk.Value{}, fmt.Errorf("%s() returned %s, not %s", name, value.StaticType(), ret)
+		}
+		return value, nil
+	})
+
+	vm.globals.TableSet(chunk.NewGString(name), chunk.Value{
+		Type:  chunk.TypeNative,
+		Value: native,
+	})
+	vm.globalTypes[name] = chunk.NewFunctionType(params, ret)
+}
+
+// Call calls the function held by the global name with args, converted as
+// for a NativeFunc, and returns its converted result. If the function
+// fails, the error is a *RuntimeError. Call must not be used from inside a
+// NativeFunc.
+func (vm *VM) Call(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	var callee chunk.Value
+	if !vm.globals.TableGet(chunk.NewGString(name), &callee) {
+		return nil, fmt.Errorf("undefined function %s", name)
+	}
+	if len(args) > 255 {
+		return nil, fmt.Errorf("too many arguments to %s()", name)
+	}
+
+	vm.push(callee)
+	for _, arg := range args {
+		value, err := fromGo(arg)
+		if err != nil {
+			vm.resetStack()
+			return nil, fmt.Errorf("argument of %s(): %v", name, err)
+		}
+		vm.push(value)
+	}
+
+	if !vm.callValue(callee, byte(len(args))) {
+		return nil, vm.takeError()
+	}
+	if vm.FrameCount > 0 && vm.run(ctx) != InterpretOk {
+		return nil, vm.takeError()
+	}
+	return toGo(vm.pop()), nil
+}
+
+func (vm *VM) callNative(native *chunk.GNative, argCount byte) bool {
+	if int(argCount) != len(native.ParamTypes) {
+		vm.runtimeError(KindArity, "Expected %d arguments but got %d.", len(native.ParamTypes), argCount)
+		return false
+	}
+
+	args := vm.stack[vm.stackTop-int(argCount) : vm.stackTop]
+	if !vm.checkArguments(native.Name.String+"()", native.ParamTypes, args) {
+		return false
+	}
+
+	result, err := native.Function(args)
+	if err != nil {
+		vm.runtimeError(KindNative, "%v", err)
+		return false
+	}
+	vm.stackTop -= int(argCount) + 1
+	vm.push(result)
+	return true
+}
+
+func toGo(value chunk.Value) interface{} {
+	switch value.Type {
+	case chunk.TypeBool:
+		return value.Bool()
+	case chunk.TypeInteger:
+		return value.Integer()
+	case chunk.TypeFloat:
+		return value.Float()
+	case chunk.TypeString:
+		return value.String()
+	case chunk.TypeNull:
+		return nil
+	}
+	return value
+}
+
+func fromGo(value interface{}) (chunk.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return chunk.Value{Type: chunk.TypeNull}, nil
+	case bool:
+		return chunk.Value{Type: chunk.TypeBool, Value: v}, nil
+	case int:
+		return chunk.Value{Type: chunk.TypeInteger, Value: int64(v)}, nil
+	case int64:
+		return chunk.Value{Type: chunk.TypeInteger, Value: v}, nil
+	case float64:
+		return chunk.Value{Type: chunk.TypeFloat, Value: v}, nil
+	case string:
+		return chunk.Value{Type: chunk.TypeString, Value: chunk.NewGString(v)}, nil
+	case chunk.Value:
+		return v, nil
+	}
+	return chunk.Value{}, fmt.Errorf("unsupported Go value of type %T", value)
+}
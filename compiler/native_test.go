@@ -0,0 +1,150 @@
+package compiler
+
+import (
+	"GNBS/chunk"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newNativeVM() *VM {
+	vm := NewVM(Options{})
+	vm.RegisterNative("carre", []*chunk.Type{chunk.IntType}, chunk.IntType, func(args ...interface{}) (interface{}, error) {
+		n := args[0].(int64)
+		return n * n, nil
+	})
+	vm.RegisterNative("majuscules", []*chunk.Type{chunk.StringType}, chunk.StringType, func(args ...interface{}) (interface{}, error) {
+		return strings.ToUpper(args[0].(string)), nil
+	})
+	vm.RegisterNative("moyenne", []*chunk.Type{chunk.FloatType, chunk.FloatType}, chunk.FloatType, func(args ...interface{}) (interface{}, error) {
+		return (args[0].(float64) + args[1].(float64)) / 2, nil
+	})
+	vm.RegisterNative("echouer", nil, chunk.NullType, func(args ...interface{}) (interface{}, error) {
+		return nil, errors.New("échec voulu")
+	})
+	vm.RegisterNative("mentir", nil, chunk.IntType, func(args ...interface{}) (interface{}, error) {
+		return "pas un entier", nil
+	})
+	vm.RegisterNative("premier", []*chunk.Type{nil, nil}, nil, func(args ...interface{}) (interface{}, error) {
+		return args[0], nil
+	})
+	return vm
+}
+
+func TestNatives(t *testing.T) {
+	vm := newNativeVM()
+	src := `
+var c = carre(7)
+var m = majuscules("gnbs")
+var moy = moyenne(1.0, 2.0)
+var f: fonction = carre
+var viaVariable = f(3)
+var p = premier("a", 1)
+var n = premier(nul, 2)
+`
+	if result, _ := vm.Interpret(context.Background(), []byte(src)); result != InterpretOk {
+		t.Fatalf("got result %d", result)
+	}
+	want := map[string]interface{}{"c": int64(49), "m": "GNBS", "moy": 1.5, "viaVariable": int64(9), "p": "a", "n": nil}
+	for name, value := range want {
+		if got := toGo(globalValue(t, vm, name)); got != value {
+			t.Errorf("%s = %v, want %v", name, got, value)
+		}
+	}
+}
+
+func TestNativeErrors(t *testing.T) {
+	ctx := context.Background()
+
+	for _, src := range []string{"carre(\"a\")\n", "moyenne(1.0)\n"} {
+		if result, _ := newNativeVM().Interpret(ctx, []byte(src)); result != InterpretCompileError {
+			t.Errorf("%q: got result %d, want a compile error", src, result)
+		}
+	}
+	for _, src := range []string{"echouer()\n", "mentir()\n", "var f = nul\nf = carre\nf(1.5)\n"} {
+		if result, _ := newNativeVM().Interpret(ctx, []byte(src)); result != InterpretRuntimeError {
+			t.Errorf("%q: got result %d, want a runtime error", src, result)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	vm := newNativeVM()
+	ctx := context.Background()
+	src := `
+fonction somme(a: Ent, b: Ent) -> Ent {
+	revenir a + b
+}
+fonction salut(nom: Cha) -> Cha {
+	revenir "salut " + majuscules(nom)
+}
+fonction rien() {
+}
+fonction diviser(a: Ent, b: Ent) -> Ent {
+	revenir a / b
+}
+var x = 1
+`
+	if result, _ := vm.Interpret(ctx, []byte(src)); result != InterpretOk {
+		t.Fatalf("got result %d", result)
+	}
+
+	tests := []struct {
+		name string
+		args []interface{}
+		want interface{}
+	}{
+		{"somme", []interface{}{1, int64(2)}, int64(3)},
+		{"salut", []interface{}{"toi"}, "salut TOI"},
+		{"rien", nil, nil},
+		{"carre", []interface{}{5}, int64(25)},
+	}
+	for _, test := range tests {
+		got, err := vm.Call(ctx, test.name, test.args...)
+		if err != nil || got != test.want {
+			t.Errorf("%s(%v) = %v, %v, want %v", test.name, test.args, got, err, test.want)
+		}
+	}
+
+	for _, call := range []struct {
+		name string
+		args []interface{}
+	}{
+		{"somme", []interface{}{1, "deux"}},
+		{"somme", []interface{}{1}},
+		{"somme", []interface{}{1, struct{}{}}},
+		{"x", nil},
+		{"inconnue", nil},
+	} {
+		if _, err := vm.Call(ctx, call.name, call.args...); err == nil {
+			t.Errorf("%s(%v) did not fail", call.name, call.args)
+		}
+	}
+
+	_, err := vm.Call(ctx, "diviser", 1, 0)
+	if runtimeErr, ok := err.(*RuntimeError); !ok || runtimeErr.Kind != KindDivisionByZero || len(runtimeErr.Frames) != 1 {
+		t.Errorf("diviser(1, 0) failed with %v, want a division by zero", err)
+	}
+
+	if got, err := vm.Call(ctx, "somme", 20, 22); err != nil || got != int64(42) {
+		t.Errorf("the VM is unusable after failed calls: %v, %v", got, err)
+	}
+}
+
+// TestReadmeEmbedding runs the embedding example of the README.
+func TestReadmeEmbedding(t *testing.T) {
+	ctx := context.Background()
+	vm := NewVM(Options{})
+	vm.RegisterNative("carre", []*chunk.Type{chunk.IntType}, chunk.IntType,
+		func(args ...interface{}) (interface{}, error) {
+			n := args[0].(int64)
+			return n * n, nil
+		})
+	if result, err := vm.Interpret(ctx, []byte("fonction somme(a: Ent, b: Ent) -> Ent { revenir carre(a) + b }\n")); result != InterpretOk {
+		t.Fatalf("got result %d, %v", result, err)
+	}
+	if total, err := vm.Call(ctx, "somme", 3, 4); err != nil || total != int64(13) {
+		t.Errorf("somme(3, 4) = %v, %v, want 13", total, err)
+	}
+}
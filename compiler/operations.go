@@ -1,6 +1,9 @@
 package compiler
 
-import "GNBS/chunk"
+import (
+	"GNBS/chunk"
+	"math"
+)
 
 const (
 	OpReturn byte = iota
@@ -8,6 +11,9 @@ const (
 	OpSubtract
 	OpMultiply
 	OpDivide
+	OpModulo
+	OpFloorDivide
+	OpPower
 	OpConstant
 	OpNegate
 	OpNull
@@ -32,118 +38,254 @@ const (
 	OpLoop
 
 	OpCall
+
+	OpClass
+	OpField
+	OpMethod
+	OpGetProperty
+	OpSetProperty
+	OpInherit
+	OpGetSuper
+
+	OpClosure
+	OpGetUpvalue
+	OpSetUpvalue
+	OpCloseUpvalue
+
+	// The long forms of the instructions on constants, for an index past
+	// 255. Their index is on three bytes.
+	OpConstantLong
+	OpDefineGlobalLong
+	OpGetGlobalLong
+	OpSetGlobalLong
+	OpClassLong
+	OpFieldLong
+	OpMethodLong
+	OpGetPropertyLong
+	OpSetPropertyLong
+	OpGetSuperLong
+	OpClosureLong
 )
 
-func binaryOperation(operation byte) InterpretResult {
-	if operation == OpAdd {
-		val, val2 := peek(0), peek(1)
-		if val.Type == chunk.TypeString && val2.Type == chunk.TypeString {
-			val, val2 = pop(), pop()
-			push(chunk.Value{
-				Type:  chunk.TypeString,
-				Value: chunk.NewGString(val.String() + val2.String()),
-			})
-		}
-	}
+// MaxConstants is the highest index of a constant in a chunk, the largest
+// operand of the long instructions.
+const MaxConstants = 1<<24 - 1
 
-	if val, val2 := peek(0), peek(1);
-		(val.Type != chunk.TypeFloat && val2.Type != chunk.TypeFloat) ||
-			(val.Type != chunk.TypeInteger && val2.Type != chunk.TypeInteger) {
-		runtimeError("Operands must be numbers.")
-		return InterpretRuntimeError
+// longForms maps the instructions on constants to their long form.
+var longForms = map[byte]byte{
+	OpConstant:     OpConstantLong,
+	OpDefineGlobal: OpDefineGlobalLong,
+	OpGetGlobal:    OpGetGlobalLong,
+	OpSetGlobal:    OpSetGlobalLong,
+	OpClass:        OpClassLong,
+	OpField:        OpFieldLong,
+	OpMethod:       OpMethodLong,
+	OpGetProperty:  OpGetPropertyLong,
+	OpSetProperty:  OpSetPropertyLong,
+	OpGetSuper:     OpGetSuperLong,
+	OpClosure:      OpClosureLong,
+}
+
+// isLong reports whether instruction is the long form of another.
+func isLong(instruction byte) bool {
+	return instruction >= OpConstantLong
+}
+
+func (vm *VM) binaryOperation(operation byte) InterpretResult {
+	val2, val := vm.peek(0), vm.peek(1)
+
+	if operation == OpAdd && val.Type == chunk.TypeString && val2.Type == chunk.TypeString {
+		vm.pop()
+		vm.pop()
+		vm.push(chunk.Value{
+			Type:  chunk.TypeString,
+			Value: chunk.NewGString(val.String() + val2.String()),
+		})
+		return InterpretOk
 	}
 
-	if val, val2 := peek(0), peek(1);
-		(val.Type != chunk.TypeInteger && val2.Type != chunk.TypeFloat) ||
-			(val.Type != chunk.TypeFloat && val2.Type != chunk.TypeInteger) {
-		runtimeError("Operands must have the same type")
+	if (val.Type != chunk.TypeInteger && val.Type != chunk.TypeFloat) || val.Type != val2.Type {
+		vm.runtimeError(KindType, "Operands must be numbers of the same type.")
 		return InterpretRuntimeError
 	}
 
-	val := pop()
-	val2 := pop()
+	vm.pop()
+	vm.pop()
 
 	switch operation {
-	case OpAdd, OpSubtract, OpMultiply, OpDivide:
+	case OpAdd, OpSubtract, OpMultiply, OpDivide, OpModulo, OpFloorDivide, OpPower:
 		if val.Type == chunk.TypeInteger {
-			return binaryIntegerOperation(operation, val, val2)
+			return vm.binaryIntegerOperation(operation, val, val2)
 		} else {
-			return binaryFloatOperation(operation, val, val2)
+			return vm.binaryFloatOperation(operation, val, val2)
 		}
 	case OpGreater, OpLess:
-		return binaryComparison(operation, val, val2)
+		return vm.binaryComparison(operation, val, val2)
 	}
 	return InterpretRuntimeError
 }
 
-func binaryIntegerOperation(operation byte, val, val2 chunk.Value) InterpretResult {
+func (vm *VM) binaryIntegerOperation(operation byte, val, val2 chunk.Value) InterpretResult {
 	switch operation {
 	case OpAdd:
-		push(chunk.Value{
+		vm.push(chunk.Value{
 			Type:  chunk.TypeInteger,
 			Value: val.Integer() + val2.Integer(),
 		})
 		break
-	case OpNegate:
-		push(chunk.Value{
+	case OpSubtract:
+		vm.push(chunk.Value{
 			Type:  chunk.TypeInteger,
 			Value: val.Integer() - val2.Integer(),
 		})
 		break
 	case OpMultiply:
-		push(chunk.Value{
+		vm.push(chunk.Value{
 			Type:  chunk.TypeInteger,
 			Value: val.Integer() * val2.Integer(),
 		})
 		break
 	case OpDivide:
-		push(chunk.Value{
+		if val2.Integer() == 0 {
+			vm.runtimeError(KindDivisionByZero, "Division by zero.")
+			return InterpretRuntimeError
+		}
+		vm.push(chunk.Value{
 			Type:  chunk.TypeInteger,
 			Value: val.Integer() / val2.Integer(),
 		})
 		break
+	case OpModulo, OpFloorDivide:
+		if val2.Integer() == 0 {
+			vm.runtimeError(KindDivisionByZero, "Division by zero.")
+			return InterpretRuntimeError
+		}
+		quotient, remainder := floorDivMod(val.Integer(), val2.Integer())
+		result := remainder
+		if operation == OpFloorDivide {
+			result = quotient
+		}
+		vm.push(chunk.Value{
+			Type:  chunk.TypeInteger,
+			Value: result,
+		})
+		break
+	case OpPower:
+		if val.Integer() == 0 && val2.Integer() < 0 {
+			vm.runtimeError(KindDivisionByZero, "Division by zero.")
+			return InterpretRuntimeError
+		}
+		vm.push(chunk.Value{
+			Type:  chunk.TypeInteger,
+			Value: integerPower(val.Integer(), val2.Integer()),
+		})
+		break
 	}
 	return InterpretOk
 }
-func binaryFloatOperation(operation byte, val, val2 chunk.Value) InterpretResult {
+
+// floorDivMod divides a by b rounding the quotient down, so that the
+// remainder has the sign of b, as for div and mod.
+func floorDivMod(a, b int64) (int64, int64) {
+	quotient, remainder := a/b, a%b
+	if remainder != 0 && (remainder < 0) != (b < 0) {
+		quotient--
+		remainder += b
+	}
+	return quotient, remainder
+}
+
+// integerPower raises base to exp. A negative exponent gives the integer
+// part of the inverse, which is only non-zero for 1 and -1.
+func integerPower(base, exp int64) int64 {
+	if exp < 0 {
+		switch {
+		case base == 1:
+			return 1
+		case base == -1 && exp%2 != 0:
+			return -1
+		case base == -1:
+			return 1
+		}
+		return 0
+	}
+
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+func (vm *VM) binaryFloatOperation(operation byte, val, val2 chunk.Value) InterpretResult {
 	switch operation {
 	case OpAdd:
-		push(chunk.Value{
-			Type:  chunk.TypeInteger,
+		vm.push(chunk.Value{
+			Type:  chunk.TypeFloat,
 			Value: val.Float() + val2.Float(),
 		})
 		break
-	case OpNegate:
-		push(chunk.Value{
-			Type:  chunk.TypeInteger,
+	case OpSubtract:
+		vm.push(chunk.Value{
+			Type:  chunk.TypeFloat,
 			Value: val.Float() - val2.Float(),
 		})
 		break
 	case OpMultiply:
-		push(chunk.Value{
-			Type:  chunk.TypeInteger,
+		vm.push(chunk.Value{
+			Type:  chunk.TypeFloat,
 			Value: val.Float() * val2.Float(),
 		})
 		break
 	case OpDivide:
-		push(chunk.Value{
-			Type:  chunk.TypeInteger,
+		if val2.Float() == 0 {
+			vm.runtimeError(KindDivisionByZero, "Division by zero.")
+			return InterpretRuntimeError
+		}
+		vm.push(chunk.Value{
+			Type:  chunk.TypeFloat,
 			Value: val.Float() / val2.Float(),
 		})
+		break
+	case OpModulo, OpFloorDivide:
+		if val2.Float() == 0 {
+			vm.runtimeError(KindDivisionByZero, "Division by zero.")
+			return InterpretRuntimeError
+		}
+		result := math.Floor(val.Float() / val2.Float())
+		if operation == OpModulo {
+			result = math.Mod(val.Float(), val2.Float())
+			if result != 0 && (result < 0) != (val2.Float() < 0) {
+				result += val2.Float()
+			}
+		}
+		vm.push(chunk.Value{
+			Type:  chunk.TypeFloat,
+			Value: result,
+		})
+		break
+	case OpPower:
+		vm.push(chunk.Value{
+			Type:  chunk.TypeFloat,
+			Value: math.Pow(val.Float(), val2.Float()),
+		})
 	}
 	return InterpretOk
 }
-func binaryComparison(operation byte, val, val2 chunk.Value) InterpretResult {
+func (vm *VM) binaryComparison(operation byte, val, val2 chunk.Value) InterpretResult {
 	if val.Type == chunk.TypeFloat {
 		switch operation {
 		case OpGreater:
-			push(chunk.Value{
+			vm.push(chunk.Value{
 				Type:  chunk.TypeBool,
 				Value: val.Float() > val2.Float(),
 			})
 			break
 		case OpLess:
-			push(chunk.Value{
+			vm.push(chunk.Value{
 				Type:  chunk.TypeBool,
 				Value: val.Float() < val2.Float(),
 			})
@@ -152,13 +294,13 @@ func binaryComparison(operation byte, val, val2 chunk.Value) InterpretResult {
 	} else {
 		switch operation {
 		case OpGreater:
-			push(chunk.Value{
+			vm.push(chunk.Value{
 				Type:  chunk.TypeBool,
 				Value: val.Integer() > val2.Integer(),
 			})
 			break
 		case OpLess:
-			push(chunk.Value{
+			vm.push(chunk.Value{
 				Type:  chunk.TypeBool,
 				Value: val.Integer() < val2.Integer(),
 			})
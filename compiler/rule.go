@@ -14,13 +14,14 @@ const (
 	Term
 	Factor
 	Unary
+	Exponent
 	Call
 	Primary
 )
 
 type ParseRule struct {
-	prefix     func(bool)
-	infix      func(bool)
+	prefix     func(*Compiler, bool)
+	infix      func(*Compiler, bool)
 	precedence Precedence
 }
 
@@ -28,41 +29,45 @@ var rules []ParseRule
 
 func init() {
 	rules = []ParseRule{
-		token.LParentheses: {grouping, callFn, Call},
+		token.LParentheses: {(*Compiler).grouping, (*Compiler).callFn, Call},
 		token.RParentheses: {nil, nil, None},
 		token.LBrace:       {nil, nil, None},
 		token.RBrace:       {nil, nil, None},
 		token.Comma:        {nil, nil, None},
-		token.Dot:          {nil, nil, None},
-		token.Minus:        {unary, binary, Term},
-		token.Plus:         {nil, grouping, Term},
+		token.Dot:          {nil, (*Compiler).dot, Call},
+		token.Minus:        {(*Compiler).unary, (*Compiler).binary, Term},
+		token.Plus:         {nil, (*Compiler).binary, Term},
 		token.Semicolon:    {nil, nil, None},
-		token.Slash:        {nil, binary, Factor},
-		token.Star:         {nil, binary, Factor},
-		token.Not:          {unary, nil, None},
-		token.NotEqual:     {nil, binary, Equality},
+		token.Slash:        {nil, (*Compiler).binary, Factor},
+		token.Star:         {nil, (*Compiler).binary, Factor},
+		token.Mod:          {nil, (*Compiler).binary, Factor},
+		token.Div:          {nil, (*Compiler).binary, Factor},
+		token.Power:        {nil, (*Compiler).binary, Exponent},
+		token.Not:          {(*Compiler).unary, nil, None},
+		token.NotEqual:     {nil, (*Compiler).binary, Equality},
 		token.Equal:        {nil, nil, None},
-		token.EqualEqual:   {nil, binary, Equality},
-		token.Less:         {nil, binary, Comparison},
-		token.LessEqual:    {nil, binary, Comparison},
-		token.Greater:      {nil, binary, Comparison},
-		token.GreaterEqual: {nil, binary, Comparison},
-		token.Identifier:   {variable, nil, None},
-		token.String:       {stringvalue, nil, None},
-		token.Float:        {floatnumber, nil, None},
-		token.Integer:      {intnumber, nil, None},
-		token.And:          {nil, and_, And},
-		token.Or:           {nil, or_, Or},
+		token.EqualEqual:   {nil, (*Compiler).binary, Equality},
+		token.Less:         {nil, (*Compiler).binary, Comparison},
+		token.LessEqual:    {nil, (*Compiler).binary, Comparison},
+		token.Greater:      {nil, (*Compiler).binary, Comparison},
+		token.GreaterEqual: {nil, (*Compiler).binary, Comparison},
+		token.Identifier:   {(*Compiler).variable, nil, None},
+		token.String:       {(*Compiler).stringvalue, nil, None},
+		token.Float:        {(*Compiler).floatnumber, nil, None},
+		token.Integer:      {(*Compiler).intnumber, nil, None},
+		token.And:          {nil, (*Compiler).and_, And},
+		token.Or:           {nil, (*Compiler).or_, Or},
 		token.Class:        {nil, nil, None},
 		token.Function:     {nil, nil, None},
-		token.True:         {literal, nil, None},
-		token.False:        {literal, nil, None},
+		token.True:         {(*Compiler).literal, nil, None},
+		token.False:        {(*Compiler).literal, nil, None},
 		token.For:          {nil, nil, None},
 		token.If:           {nil, nil, None},
 		token.Else:         {nil, nil, None},
-		token.Null:         {nil, nil, None},
+		token.Null:         {(*Compiler).literal, nil, None},
 		token.Return:       {nil, nil, None},
-		token.This:         {nil, nil, None},
+		token.This:         {(*Compiler).this_, nil, None},
+		token.Super:        {(*Compiler).super_, nil, None},
 		token.Error:        {nil, nil, None},
 		token.Eof:          {nil, nil, None},
 	}
@@ -0,0 +1,193 @@
+package compiler
+
+import (
+	"GNBS/chunk"
+	"GNBS/scanner"
+	"GNBS/token"
+	"fmt"
+)
+
+// The type checker runs alongside code generation. Every expression rule
+// pushes the static type of the value it leaves on the VM stack, and every
+// rule that consumes values pops their types and checks them. Type errors
+// don't put the parser in panic mode, so all of them are reported, and no
+// bytecode is handed out when there is any.
+
+func (c *Compiler) pushType(t *chunk.Type) {
+	c.parser.types = append(c.parser.types, t)
+}
+
+func (c *Compiler) popType() *chunk.Type {
+	n := len(c.parser.types)
+	if n == 0 {
+		return chunk.AnyType
+	}
+	t := c.parser.types[n-1]
+	c.parser.types = c.parser.types[:n-1]
+	return t
+}
+
+func (c *Compiler) typeError(tk *scanner.Token, format string, args ...interface{}) {
+	c.report(&Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeType,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     c.span(tk),
+	})
+}
+
+func (c *Compiler) globalType(name string) *chunk.Type {
+	if t, ok := c.parser.globals[name]; ok {
+		return t
+	}
+	return chunk.AnyType
+}
+
+// inferred is the type given to a variable initialised with a value of
+// type t: a variable that starts out as nul can hold anything.
+func inferred(t *chunk.Type) *chunk.Type {
+	if t.Kind == chunk.TypeNull {
+		return chunk.AnyType
+	}
+	return t
+}
+
+func (c *Compiler) checkAssignable(tk *scanner.Token, from, to *chunk.Type, context string) {
+	if !from.AssignableTo(to) {
+		c.typeError(tk, "cannot use %s as %s in %s", from, to, context)
+	}
+}
+
+func (c *Compiler) checkCondition(tk *scanner.Token) {
+	if t := c.popType(); !t.AssignableTo(chunk.BoolType) {
+		c.typeError(tk, "non-boolean condition (%s)", t)
+	}
+}
+
+// checkBoolean checks an operand of the logical operator op, which only
+// takes booleans.
+func (c *Compiler) checkBoolean(op *scanner.Token, t *chunk.Type) {
+	if !t.AssignableTo(chunk.BoolType) {
+		c.typeError(op, "operator '%s' not defined on %s", op.Token, t)
+	}
+}
+
+func (c *Compiler) checkMatching(op *scanner.Token, left, right *chunk.Type) bool {
+	if !left.AssignableTo(right) {
+		c.typeError(op, "mismatched types %s and %s for '%s'", left, right, op.Token)
+		return false
+	}
+	return true
+}
+
+// operandType is the type shared by both operands of a binary operator,
+// the known one when only one of them is known.
+func operandType(left, right *chunk.Type) *chunk.Type {
+	if left.IsAny() {
+		return right
+	}
+	return left
+}
+
+func (c *Compiler) arithmeticType(op *scanner.Token, left, right *chunk.Type) *chunk.Type {
+	if !c.checkMatching(op, left, right) {
+		return chunk.AnyType
+	}
+
+	switch operand := operandType(left, right); {
+	case operand.IsAny(), operand.IsNumeric():
+		return operand
+	case operand.Kind == chunk.TypeString && op.Token == token.Plus:
+		return operand
+	default:
+		c.typeError(op, "operator '%s' not defined on %s", op.Token, operand)
+		return chunk.AnyType
+	}
+}
+
+func (c *Compiler) comparisonType(op *scanner.Token, left, right *chunk.Type) *chunk.Type {
+	if c.checkMatching(op, left, right) {
+		if operand := operandType(left, right); !operand.IsAny() && !operand.IsNumeric() {
+			c.typeError(op, "operator '%s' not defined on %s", op.Token, operand)
+		}
+	}
+	return chunk.BoolType
+}
+
+func (c *Compiler) equalityType(op *scanner.Token, left, right *chunk.Type) *chunk.Type {
+	if left.Kind != chunk.TypeNull && right.Kind != chunk.TypeNull {
+		c.checkMatching(op, left, right)
+	}
+	return chunk.BoolType
+}
+
+func (c *Compiler) callType(tk *scanner.Token, callee *chunk.Type, args []*chunk.Type) *chunk.Type {
+	switch {
+	case callee.IsAny(), callee.Kind == chunk.TypeNative:
+		return chunk.AnyType
+	case callee.Kind == chunk.TypeClass:
+		return c.constructorType(tk, callee.Class, args)
+	case callee.Kind != chunk.TypeFunction:
+		c.typeError(tk, "cannot call non-function of type %s", callee)
+		return chunk.AnyType
+	case callee.Return == nil:
+		// a function whose signature is still being compiled
+		return chunk.AnyType
+	}
+
+	if len(args) != len(callee.Params) {
+		c.typeError(tk, "wrong number of arguments: expected %d, got %d", len(callee.Params), len(args))
+		return callee.Return
+	}
+	for i, arg := range args {
+		c.checkAssignable(tk, arg, callee.Params[i], fmt.Sprintf("argument %d", i+1))
+	}
+	return callee.Return
+}
+
+// constructorType checks a call to a class against the signature of its
+// init method; a class without one takes no arguments.
+func (c *Compiler) constructorType(tk *scanner.Token, class *chunk.ClassType, args []*chunk.Type) *chunk.Type {
+	initializer, ok := class.Methods[initializerName]
+	if !ok && !c.isCompiling(class) {
+		initializer = chunk.NewFunctionType(nil, chunk.NullType)
+	}
+	if initializer != nil {
+		c.callType(tk, initializer, args)
+	}
+	return class.InstanceType()
+}
+
+// propertyType returns the type of the property name of a value of type
+// receiver, and whether it is a field. The properties of a class are only
+// all known at the end of its body, so the ones missing from a class that
+// is being compiled are not reported.
+func (c *Compiler) propertyType(name *scanner.Token, receiver *chunk.Type) (t *chunk.Type, isField bool) {
+	if receiver.IsAny() {
+		return chunk.AnyType, true
+	}
+	if receiver.Kind != chunk.TypeInstance {
+		c.typeError(name, "%s has no field or method %s", receiver, name.LitName)
+		return chunk.AnyType, true
+	}
+
+	if t, ok := receiver.Class.Fields[name.LitName]; ok {
+		return t, true
+	}
+	if t, ok := receiver.Class.Methods[name.LitName]; ok {
+		return t, false
+	}
+	if !c.isCompiling(receiver.Class) {
+		c.typeError(name, "%s has no field or method %s", receiver, name.LitName)
+	}
+	return chunk.AnyType, true
+}
+
+func (c *Compiler) isCompiling(class *chunk.ClassType) bool {
+	for cc := c.currentClass; cc != nil; cc = cc.Enclosing {
+		if cc.Type == class {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func typeErrors(t *testing.T, src string) []string {
+	t.Helper()
+	fn, diagnostics := Compile([]byte(src))
+
+	var errs []string
+	for _, d := range diagnostics {
+		if d.Code != CodeType {
+			t.Fatalf("syntax error in %q: %v", src, d)
+		}
+		errs = append(errs, d.Message)
+	}
+	if (fn == nil) != (len(errs) > 0) {
+		t.Errorf("Compile returned %v with %d type errors", fn, len(errs))
+	}
+	return errs
+}
+
+// hasSyntaxError reports whether src fails to compile with an error that
+// is not a type error.
+func hasSyntaxError(src string) bool {
+	_, diagnostics := Compile([]byte(src))
+	for _, d := range diagnostics {
+		if d.Code != CodeType {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTypeCheckValid(t *testing.T) {
+	sources := []string{
+		"var x = 10\nvar y = x * 2 + 1\n",
+		"var f = 1.5\nf = f / 2.0\n",
+		"var s = \"a\" + \"b\"\n",
+		"var b = 1 < 2\nb = 2.0 >= 1.0\n",
+		"var n = nul\nn = 1\nn = \"a\"\n",
+		"var x = 1 == 1\nvar y = \"a\" != nul\n",
+		"fonction somme(a, b) {\n\trevenir a + b\n}\nvar z = somme(1, 2)\n",
+		"fonction un() {\n\trevenir 1\n}\nvar z = un() + 1\n",
+		"fonction signe(n: Ent) -> Ent {\n\tsi n < 0 {\n\t\trevenir -1\n\t} sinon si n == 0 {\n\t\trevenir 0\n\t} autre {\n\t\trevenir 1\n\t}\n}\n",
+		"fonction boucle() -> Ent {\n\tpendant {\n\t}\n}\n",
+		"fonction bloc() -> Ent {\n\t{\n\t\trevenir 1\n\t}\n}\n",
+		"classe A {\n}\nfonction rien() -> A {\n}\n",
+	}
+
+	for _, src := range sources {
+		if errs := typeErrors(t, src); len(errs) != 0 {
+			t.Errorf("%q: unexpected errors %v", src, errs)
+		}
+	}
+}
+
+func TestTypeCheckErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		errs []string
+	}{
+		{"var x = 1 + 1.0\n", []string{"mismatched types Ent and Flot for '+'"}},
+		{"var x = \"a\" - \"b\"\n", []string{"operator '-' not defined on Cha"}},
+		{"var x = 10\nx = \"dix\"\n", []string{"cannot use Cha as Ent in assignment to x"}},
+		{"var x = \"a\" < \"b\"\n", []string{"operator '<' not defined on Cha"}},
+		{"var x = 1 == \"1\"\n", []string{"mismatched types Ent and Cha for '=='"}},
+		{"var x = -\"a\"\n", []string{"operator '-' not defined on Cha"}},
+		{"var x = \"a\" mod \"b\"\n", []string{"operator 'mod' not defined on Cha"}},
+		{"var x = vrai div faux\n", []string{"operator 'div' not defined on Bool"}},
+		{"var x = 2 ** 0.5\n", []string{"mismatched types Ent and Flot for '**'"}},
+		{"var x: Ent = 2.0 ** 2.0\n", []string{"cannot use Flot as Ent in declaration of x"}},
+		{"var x = 1\nx()\n", []string{"cannot call non-function of type Ent"}},
+		{"fonction f(a) {\n\trevenir a\n}\nf(1, 2)\n", []string{"wrong number of arguments: expected 1, got 2"}},
+		{"fonction un() {\n\trevenir 1\n}\nvar s = un() + \"a\"\n", []string{"mismatched types Ent and Cha for '+'"}},
+		{"fonction f() {\n\trevenir 1\n\trevenir \"a\"\n}\n", []string{"cannot use Cha as Ent in return statement"}},
+		{"si 1 {\n}\n", []string{"non-boolean condition (Ent)"}},
+		{"fonction g() -> Ent {\n}\nafficher g() + 1\n", []string{"missing return at the end of a function returning Ent"}},
+		{"fonction f(n: Ent) {\n\tsi n > 0 {\n\t\trevenir 1\n\t}\n}\n", []string{"missing return at the end of a function returning Ent"}},
+		{"fonction f(n: Ent) -> Ent {\n\tsi n > 0 {\n\t\trevenir 1\n\t} sinon si n < 0 {\n\t\trevenir -1\n\t}\n}\n", []string{"missing return at the end of a function returning Ent"}},
+		{"fonction f() -> Ent {\n\tpendant {\n\t\tcasser\n\t}\n}\n", []string{"missing return at the end of a function returning Ent"}},
+		{"fonction f(x: Bool, y: Bool) -> Ent { si x { revenir 1 } sinon si y {} autre { revenir 2 } }\n", []string{"missing return at the end of a function returning Ent"}},
+		{"fonction f(x: Bool) -> Ent { si x { revenir 1 } autre {} }\n", []string{"missing return at the end of a function returning Ent"}},
+		{
+			"var x = 1 + 1.0\nvar y = \"a\" * 2\nvar z = 1\nz = vrai\n",
+			[]string{
+				"mismatched types Ent and Flot for '+'",
+				"mismatched types Cha and Ent for '*'",
+				"cannot use Bool as Ent in assignment to z",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		errs := typeErrors(t, test.src)
+		if strings.Join(errs, "\n") != strings.Join(test.errs, "\n") {
+			t.Errorf("%q:\ngot  %q\nwant %q", test.src, errs, test.errs)
+		}
+	}
+}
+
+func TestTypeErrorPosition(t *testing.T) {
+	_, diagnostics := Compile([]byte("var a = 1\n\nvar b = a + \"x\"\n"))
+	if len(diagnostics) != 1 {
+		t.Fatalf("got %d errors", len(diagnostics))
+	}
+	if pos := diagnostics[0].Span.Start; pos.Line != 3 || pos.Column != 11 {
+		t.Errorf("error at %d:%d, want 3:11", pos.Line, pos.Column)
+	}
+}
+
+func TestDeclarationTypes(t *testing.T) {
+	tests := map[string]string{
+		"var x: Ent = \"a\"\n":      "cannot use Cha as Ent in declaration of x",
+		"x := 10\nx = \"dix\"\n":    "cannot use Cha as Ent in assignment to x",
+		"var f: Flot\nf = 1\n":      "cannot use Ent as Flot in assignment to f",
+		"s := \"a\"\nvar n = s + 1": "mismatched types Cha and Ent for '+'",
+	}
+
+	for src, want := range tests {
+		if errs := typeErrors(t, src); len(errs) != 1 || errs[0] != want {
+			t.Errorf("%q: got %q, want %q", src, errs, want)
+		}
+	}
+}
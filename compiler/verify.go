@@ -0,0 +1,431 @@
+package compiler
+
+import (
+	"GNBS/chunk"
+	"fmt"
+	"math"
+)
+
+// VerifyError is code that the VM can't run safely, such as a damaged
+// bytecode file.
+type VerifyError struct {
+	// Function is the name of the function, "script" for the top level.
+	Function string
+	// Offset is the offset in the code of the instruction at fault.
+	Offset  int
+	Message string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("invalid bytecode in %s at offset %d: %s", e.Function, e.Offset, e.Message)
+}
+
+// The operands of an instruction.
+const (
+	operandNone = iota
+	// operandConstant is the index of a value constant.
+	operandConstant
+	// operandName is the index of a string constant.
+	operandName
+	operandLocal
+	operandUpvalue
+	operandArgCount
+	// operandJump is a forward offset on two bytes.
+	operandJump
+	// operandLoop is a backward offset on two bytes.
+	operandLoop
+	// operandClosure is the index of a function constant, followed by a
+	// pair of bytes for each of its upvalues.
+	operandClosure
+)
+
+// The indexes of constants are on three bytes in the long instructions.
+
+// instruction is what the verifier knows of an opcode: its operand and how
+// many values it pops from and pushes on the stack.
+type instruction struct {
+	name    string
+	operand int
+	pops    int
+	pushes  int
+}
+
+var instructions = [...]instruction{
+	OpReturn:       {"OP_RETURN", operandNone, 1, 0},
+	OpAdd:          {"OP_ADD", operandNone, 2, 1},
+	OpSubtract:     {"OP_SUBTRACT", operandNone, 2, 1},
+	OpMultiply:     {"OP_MULTIPLY", operandNone, 2, 1},
+	OpDivide:       {"OP_DIVIDE", operandNone, 2, 1},
+	OpModulo:       {"OP_MODULO", operandNone, 2, 1},
+	OpFloorDivide:  {"OP_FLOOR_DIVIDE", operandNone, 2, 1},
+	OpPower:        {"OP_POWER", operandNone, 2, 1},
+	OpConstant:     {"OP_CONSTANT", operandConstant, 0, 1},
+	OpNegate:       {"OP_NEGATE", operandNone, 1, 1},
+	OpNull:         {"OP_NULL", operandNone, 0, 1},
+	OpTrue:         {"OP_TRUE", operandNone, 0, 1},
+	OpFalse:        {"OP_FALSE", operandNone, 0, 1},
+	OpNot:          {"OP_NOT", operandNone, 1, 1},
+	OpEqual:        {"OP_EQUAL", operandNone, 2, 1},
+	OpGreater:      {"OP_GREATER", operandNone, 2, 1},
+	OpLess:         {"OP_LESS", operandNone, 2, 1},
+	OpPrint:        {"OP_PRINT", operandNone, 1, 0},
+	OpPop:          {"OP_POP", operandNone, 1, 0},
+	OpDefineGlobal: {"OP_DEFINE_GLOBAL", operandName, 1, 0},
+	OpGetGlobal:    {"OP_GET_GLOBAL", operandName, 0, 1},
+	OpGetLocal:     {"OP_GET_LOCAL", operandLocal, 0, 1},
+	OpSetGlobal:    {"OP_SET_GLOBAL", operandName, 1, 1},
+	OpSetLocal:     {"OP_SET_LOCAL", operandLocal, 1, 1},
+	OpJump:         {"OP_JUMP", operandJump, 0, 0},
+	OpJumpIfFalse:  {"OP_JUMP_IF_FALSE", operandJump, 1, 1},
+	OpLoop:         {"OP_LOOP", operandLoop, 0, 0},
+	OpCall:         {"OP_CALL", operandArgCount, 1, 1},
+	OpClass:        {"OP_CLASS", operandName, 0, 1},
+	OpField:        {"OP_FIELD", operandName, 2, 1},
+	OpMethod:       {"OP_METHOD", operandName, 2, 1},
+	OpGetProperty:  {"OP_GET_PROPERTY", operandName, 1, 1},
+	OpSetProperty:  {"OP_SET_PROPERTY", operandName, 2, 1},
+	OpInherit:      {"OP_INHERIT", operandNone, 2, 1},
+	OpGetSuper:     {"OP_GET_SUPER", operandName, 2, 1},
+	OpClosure:      {"OP_CLOSURE", operandClosure, 0, 1},
+	OpGetUpvalue:   {"OP_GET_UPVALUE", operandUpvalue, 0, 1},
+	OpSetUpvalue:   {"OP_SET_UPVALUE", operandUpvalue, 1, 1},
+	OpCloseUpvalue: {"OP_CLOSE_UPVALUE", operandNone, 1, 0},
+
+	OpConstantLong:     {"OP_CONSTANT_LONG", operandConstant, 0, 1},
+	OpDefineGlobalLong: {"OP_DEFINE_GLOBAL_LONG", operandName, 1, 0},
+	OpGetGlobalLong:    {"OP_GET_GLOBAL_LONG", operandName, 0, 1},
+	OpSetGlobalLong:    {"OP_SET_GLOBAL_LONG", operandName, 1, 1},
+	OpClassLong:        {"OP_CLASS_LONG", operandName, 0, 1},
+	OpFieldLong:        {"OP_FIELD_LONG", operandName, 2, 1},
+	OpMethodLong:       {"OP_METHOD_LONG", operandName, 2, 1},
+	OpGetPropertyLong:  {"OP_GET_PROPERTY_LONG", operandName, 1, 1},
+	OpSetPropertyLong:  {"OP_SET_PROPERTY_LONG", operandName, 2, 1},
+	OpGetSuperLong:     {"OP_GET_SUPER_LONG", operandName, 2, 1},
+	OpClosureLong:      {"OP_CLOSURE_LONG", operandClosure, 0, 1},
+}
+
+// Verify checks that the script fn, and the functions declared in it, can
+// run without crashing the VM: every opcode is known, its operands are in
+// bounds and its constants of the right type, jumps land on instructions,
+// and the stack has the same depth on every path to an instruction, deep
+// enough for it and no deeper than a call may use. It returns a
+// *VerifyError for the first problem found.
+//
+// Code produced by the compiler always passes; Run verifies code that
+// comes from elsewhere.
+func Verify(fn *chunk.GFunction) error {
+	if fn.Arity != 0 || fn.UpvalueCount != 0 {
+		return &VerifyError{Function: "script", Message: "the script has parameters or upvalues"}
+	}
+	return verifyFunction(fn)
+}
+
+func verifyFunction(fn *chunk.GFunction) error {
+	v := verifier{fn: fn, code: fn.Chunk.Code}
+	if err := v.verify(); err != nil {
+		return err
+	}
+	for i, value := range fn.Chunk.Values {
+		if inner, ok := value.Value.(*chunk.GFunction); ok && value.Type == chunk.TypeFunction {
+			// Only the script has no name.
+			if inner.Name == nil {
+				return &VerifyError{Function: functionName(fn), Message: fmt.Sprintf("constant %d is a function without a name", i)}
+			}
+			if err := verifyFunction(inner); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	fn   *chunk.GFunction
+	code []byte
+
+	// size is the size of the instruction at each offset, 0 for the
+	// offsets inside an instruction.
+	size []int
+	// depth is the depth of the stack before each instruction, relative
+	// to the base of the call, or -1 until a path reaches it.
+	depth []int
+	// overflow is set when the stack of the call grows past FrameSlots.
+	overflow bool
+}
+
+func (v *verifier) errorf(offset int, format string, args ...interface{}) error {
+	return &VerifyError{
+		Function: functionName(v.fn),
+		Offset:   offset,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (v *verifier) verify() error {
+	fn := v.fn
+	switch {
+	case len(v.code) == 0:
+		return v.errorf(0, "no code")
+	case fn.Arity > math.MaxUint8 || len(fn.ParamTypes) != fn.Arity:
+		return v.errorf(0, "arity %d with %d parameter types", fn.Arity, len(fn.ParamTypes))
+	case fn.UpvalueCount > math.MaxUint8+1:
+		return v.errorf(0, "%d upvalues", fn.UpvalueCount)
+	case !validType(fn.ReturnType, true):
+		return v.errorf(0, "invalid return type")
+	}
+	for i, param := range fn.ParamTypes {
+		if !validType(param, false) {
+			return v.errorf(0, "invalid type for parameter %d", i+1)
+		}
+	}
+
+	if err := v.decode(); err != nil {
+		return err
+	}
+	return v.trace()
+}
+
+// validType reports whether t is a type the VM can check values against:
+// present unless optional, with a class for the class and instance types,
+// and valid parameter and return types for the function types. An absent
+// return type is that of a function without a signature.
+func validType(t *chunk.Type, optional bool) bool {
+	if t == nil {
+		return optional
+	}
+	switch t.Kind {
+	case chunk.TypeClass, chunk.TypeInstance:
+		return t.Class != nil
+	case chunk.TypeFunction:
+		for _, param := range t.Params {
+			if !validType(param, false) {
+				return false
+			}
+		}
+		return validType(t.Return, true)
+	}
+	return true
+}
+
+// decode goes through the code once, checking the opcodes and the
+// operands that don't depend on the stack.
+func (v *verifier) decode() error {
+	v.size = make([]int, len(v.code))
+	for offset := 0; offset < len(v.code); {
+		size, err := v.checkOperands(offset)
+		if err != nil {
+			return err
+		}
+		v.size[offset] = size
+		offset += size
+	}
+	return nil
+}
+
+// checkOperands checks the instruction at offset and returns its size.
+func (v *verifier) checkOperands(offset int) (int, error) {
+	op := v.code[offset]
+	if int(op) >= len(instructions) {
+		return 0, v.errorf(offset, "unknown opcode %d", op)
+	}
+	inst := instructions[op]
+
+	size := 1
+	switch inst.operand {
+	case operandConstant, operandName, operandClosure:
+		size = 2
+		if isLong(op) {
+			size = 4
+		}
+	case operandLocal, operandUpvalue, operandArgCount:
+		size = 2
+	case operandJump, operandLoop:
+		size = 3
+	}
+	if offset+size > len(v.code) {
+		return 0, v.errorf(offset, "%s truncated", inst.name)
+	}
+	if size == 1 {
+		return size, nil
+	}
+
+	operand := int(v.code[offset+1])
+	values := v.fn.Chunk.Values
+	switch inst.operand {
+	case operandConstant, operandName, operandClosure:
+		if isLong(op) {
+			operand = operand<<16 | int(v.code[offset+2])<<8 | int(v.code[offset+3])
+		}
+		if operand >= len(values) {
+			return 0, v.errorf(offset, "%s uses constant %d of %d", inst.name, operand, len(values))
+		}
+	}
+
+	switch inst.operand {
+	case operandConstant:
+		if !isValueConstant(values[operand]) {
+			return 0, v.errorf(offset, "%s uses constant %d, which is not a value", inst.name, operand)
+		}
+	case operandName:
+		if name, ok := values[operand].Value.(*chunk.GString); !ok || name == nil || values[operand].Type != chunk.TypeString {
+			return 0, v.errorf(offset, "%s uses constant %d, which is not a name", inst.name, operand)
+		}
+	case operandUpvalue:
+		if operand >= v.fn.UpvalueCount {
+			return 0, v.errorf(offset, "%s uses upvalue %d of %d", inst.name, operand, v.fn.UpvalueCount)
+		}
+	case operandClosure:
+		inner, ok := values[operand].Value.(*chunk.GFunction)
+		if !ok || inner == nil || values[operand].Type != chunk.TypeFunction {
+			return 0, v.errorf(offset, "%s uses constant %d, which is not a function", inst.name, operand)
+		}
+		upvalues := offset + size
+		size += 2 * inner.UpvalueCount
+		if offset+size > len(v.code) {
+			return 0, v.errorf(offset, "%s truncated", inst.name)
+		}
+		for i := upvalues; i < offset+size; i += 2 {
+			isLocal, index := v.code[i], int(v.code[i+1])
+			if isLocal > 1 {
+				return 0, v.errorf(offset, "%s captures an upvalue of kind %d", inst.name, isLocal)
+			}
+			if isLocal == 0 && index >= v.fn.UpvalueCount {
+				return 0, v.errorf(offset, "%s captures upvalue %d of %d", inst.name, index, v.fn.UpvalueCount)
+			}
+		}
+	}
+	return size, nil
+}
+
+// isValueConstant reports whether value is a constant that OpConstant can
+// push.
+func isValueConstant(value chunk.Value) bool {
+	var ok bool
+	switch value.Type {
+	case chunk.TypeBool:
+		_, ok = value.Value.(bool)
+	case chunk.TypeInteger:
+		_, ok = value.Value.(int64)
+	case chunk.TypeFloat:
+		_, ok = value.Value.(float64)
+	case chunk.TypeString:
+		s, isString := value.Value.(*chunk.GString)
+		ok = isString && s != nil
+	case chunk.TypeNull:
+		ok = value.Value == nil
+	}
+	return ok
+}
+
+// trace follows every path through the code from its start, where the
+// stack holds the callee and the arguments, and checks the depth of the
+// stack along the way.
+func (v *verifier) trace() error {
+	v.depth = make([]int, len(v.code))
+	for i := range v.depth {
+		v.depth[i] = -1
+	}
+	v.depth[0] = v.fn.Arity + 1
+	pending := []int{0}
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		next, err := v.step(offset)
+		if err != nil {
+			return err
+		}
+		after := v.depth[offset] + v.effect(offset)
+		for _, target := range next {
+			switch v.depth[target] {
+			case -1:
+				v.depth[target] = after
+				pending = append(pending, target)
+			case after:
+			default:
+				return v.errorf(target, "stack depth %d on one path and %d on another", v.depth[target], after)
+			}
+		}
+	}
+	return nil
+}
+
+// effect returns how the instruction at offset changes the depth of the
+// stack.
+func (v *verifier) effect(offset int) int {
+	op := v.code[offset]
+	inst := instructions[op]
+	if op == OpCall {
+		return -int(v.code[offset+1])
+	}
+	return inst.pushes - inst.pops
+}
+
+// step checks the instruction at offset against the depth of the stack
+// before it and returns the offsets it may continue at.
+func (v *verifier) step(offset int) ([]int, error) {
+	op := v.code[offset]
+	inst := instructions[op]
+	depth := v.depth[offset]
+
+	pops := inst.pops
+	if op == OpCall {
+		pops += int(v.code[offset+1])
+	}
+	if depth < pops {
+		return nil, v.errorf(offset, "%s needs %d values on a stack of %d", inst.name, pops, depth)
+	}
+	if after := depth + v.effect(offset); after > FrameSlots {
+		v.overflow = true
+		return nil, v.errorf(offset, "stack of %d values, more than %d", after, FrameSlots)
+	}
+
+	size := v.size[offset]
+	switch inst.operand {
+	case operandLocal:
+		if slot := int(v.code[offset+1]); slot >= depth {
+			return nil, v.errorf(offset, "%s uses slot %d of a stack of %d", inst.name, slot, depth)
+		}
+	case operandClosure:
+		// The closure is pushed before it captures its upvalues, so that
+		// a local function can capture itself.
+		upvalues := offset + 2
+		if isLong(op) {
+			upvalues = offset + 4
+		}
+		for i := upvalues; i < offset+size; i += 2 {
+			if v.code[i] == 1 && int(v.code[i+1]) > depth {
+				return nil, v.errorf(offset, "%s captures slot %d of a stack of %d", inst.name, v.code[i+1], depth+1)
+			}
+		}
+	case operandJump, operandLoop:
+		jump := int(v.code[offset+1])<<8 | int(v.code[offset+2])
+		target := offset + 3 + jump
+		if inst.operand == operandLoop {
+			target = offset + 3 - jump
+		}
+		if target < 0 || target >= len(v.code) || v.size[target] == 0 {
+			return nil, v.errorf(offset, "%s to %d, which is not an instruction", inst.name, target)
+		}
+		if op == OpJumpIfFalse {
+			return v.fallThrough(offset, size, target)
+		}
+		return []int{target}, nil
+	}
+
+	if op == OpReturn {
+		return nil, nil
+	}
+	return v.fallThrough(offset, size)
+}
+
+// fallThrough returns the instruction after the one at offset, which is
+// size bytes long, followed by the other targets.
+func (v *verifier) fallThrough(offset, size int, targets ...int) ([]int, error) {
+	next := offset + size
+	if next >= len(v.code) {
+		return nil, v.errorf(offset, "%s runs past the end of the code", instructions[v.code[offset]].name)
+	}
+	return append([]int{next}, targets...), nil
+}
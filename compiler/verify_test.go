@@ -0,0 +1,192 @@
+package compiler
+
+import (
+	"GNBS/chunk"
+	"GNBS/token"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// assemble returns a function with the given code and constants.
+func assemble(code []byte, values ...chunk.Value) *chunk.GFunction {
+	fn := chunk.NewGFunction()
+	for _, b := range code {
+		fn.Chunk.WriteChunk(b, token.Position{Line: 1, Column: 1})
+	}
+	fn.Chunk.Values = values
+	return fn
+}
+
+func TestVerify(t *testing.T) {
+	integer := chunk.Value{Type: chunk.TypeInteger, Value: int64(1)}
+	name := chunk.Value{Type: chunk.TypeString, Value: chunk.NewGString("x")}
+
+	inner := assemble([]byte{OpGetLocal, 2, OpReturn})
+	inner.Name = chunk.NewGString("f")
+	inner.Arity = 1
+	inner.ParamTypes = []*chunk.Type{chunk.IntType}
+	function := chunk.Value{Type: chunk.TypeFunction, Value: inner}
+
+	untyped := assemble([]byte{OpNull, OpReturn})
+	untyped.Name = chunk.NewGString("g")
+	untyped.Arity = 1
+	untyped.ParamTypes = []*chunk.Type{nil}
+	noClass := assemble([]byte{OpNull, OpReturn})
+	noClass.Name = chunk.NewGString("h")
+	noClass.Arity = 1
+	noClass.ParamTypes = []*chunk.Type{chunk.NewFunctionType([]*chunk.Type{{Kind: chunk.TypeInstance}}, nil)}
+
+	withUpvalue := assemble([]byte{OpGetUpvalue, 0, OpReturn})
+	withUpvalue.Name = chunk.NewGString("lire")
+	withUpvalue.UpvalueCount = 1
+	closure := chunk.Value{Type: chunk.TypeFunction, Value: withUpvalue}
+
+	tests := []struct {
+		fn   *chunk.GFunction
+		want string
+	}{
+		{assemble([]byte{OpConstant, 0, OpPrint, OpNull, OpReturn}, integer), ""},
+		{assemble([]byte{OpNull, OpClosure, 0, 1, 1, OpCall, 0, OpReturn}, closure), ""},
+		{assemble([]byte{OpNull, OpClosureLong, 0, 0, 0, 1, 1, OpCall, 0, OpReturn}, closure), ""},
+		{assemble([]byte{OpTrue, OpJumpIfFalse, 0, 5, OpPop, OpNull, OpJump, 0, 2, OpPop, OpNull, OpReturn}), ""},
+
+		{assemble([]byte{200}), "script at offset 0: unknown opcode 200"},
+		{assemble([]byte{OpNull, OpConstant}), "offset 1: OP_CONSTANT truncated"},
+		{assemble([]byte{OpConstant, 1, OpReturn}, integer), "OP_CONSTANT uses constant 1 of 1"},
+		{assemble([]byte{OpConstant, 0, OpReturn}, function), "constant 0, which is not a value"},
+		{assemble([]byte{OpGetGlobal, 0, OpReturn}, integer), "constant 0, which is not a name"},
+		{assemble([]byte{OpClosure, 0, OpReturn}, name), "constant 0, which is not a function"},
+		{assemble([]byte{OpConstantLong, 0, 0, 1, OpReturn}, integer), "OP_CONSTANT_LONG uses constant 1 of 1"},
+		{assemble([]byte{OpNull, OpGetGlobalLong, 0, 0}, name), "offset 1: OP_GET_GLOBAL_LONG truncated"},
+		{assemble([]byte{OpGetUpvalue, 0, OpReturn}), "OP_GET_UPVALUE uses upvalue 0 of 0"},
+		{assemble([]byte{OpClosure, 0, 0, 0, OpReturn}, closure), "OP_CLOSURE captures upvalue 0 of 0"},
+		{assemble([]byte{OpClosure, 0, 2, 0, OpReturn}, closure), "captures an upvalue of kind 2"},
+		{assemble([]byte{OpClosure, 0, 1, 3, OpReturn}, closure), "captures slot 3 of a stack of 2"},
+		{assemble([]byte{OpJump, 0, 1, OpConstant, 0, OpReturn}, integer), "OP_JUMP to 4, which is not an instruction"},
+		{assemble([]byte{OpJump, 0, 9, OpNull, OpReturn}), "OP_JUMP to 12, which is not an instruction"},
+		{assemble([]byte{OpNull, OpLoop, 0, 9, OpReturn}), "OP_LOOP to -5, which is not an instruction"},
+		{assemble([]byte{OpAdd, OpReturn}), "OP_ADD needs 2 values on a stack of 1"},
+		{assemble([]byte{OpNull, OpCall, 3, OpReturn}), "OP_CALL needs 4 values on a stack of 2"},
+		{assemble([]byte{OpGetLocal, 1, OpReturn}), "OP_GET_LOCAL uses slot 1 of a stack of 1"},
+		{assemble([]byte{OpNull, OpLoop, 0, 4}), "offset 0: stack depth 1 on one path and 2 on another"},
+		{assemble([]byte{OpTrue, OpJumpIfFalse, 0, 1, OpNull, OpNull, OpReturn}), "offset 5: stack depth 2 on one path and 3 on another"},
+		{assemble([]byte{OpNull, OpPop}), "OP_POP runs past the end of the code"},
+		{assemble(nil), "no code"},
+		{assemble([]byte{OpNull, OpReturn}, chunk.Value{Type: chunk.TypeFunction, Value: untyped}), "invalid bytecode in g() at offset 0: invalid type for parameter 1"},
+		{assemble([]byte{OpNull, OpReturn}, chunk.Value{Type: chunk.TypeFunction, Value: noClass}), "invalid bytecode in h() at offset 0: invalid type for parameter 1"},
+		{assemble([]byte{OpConstant, 0, OpCall, 0, OpReturn}, integer, function), "invalid bytecode in f() at offset 0: OP_GET_LOCAL uses slot 2 of a stack of 2"},
+	}
+	for _, test := range tests {
+		err := Verify(test.fn)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%v: got error %v", test.fn.Chunk.Code, err)
+			}
+			continue
+		}
+		var verifyErr *VerifyError
+		if !errors.As(err, &verifyErr) || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: got error %v, want %q", test.fn.Chunk.Code, err, test.want)
+		}
+	}
+
+	deep := make([]byte, 0, FrameSlots+1)
+	for i := 0; i < FrameSlots; i++ {
+		deep = append(deep, OpNull)
+	}
+	if err := Verify(assemble(append(deep, OpReturn))); err == nil || !strings.Contains(err.Error(), "stack of 513 values, more than 512") {
+		t.Errorf("got error %v for a stack too deep", err)
+	}
+
+	script := assemble([]byte{OpNull, OpReturn})
+	script.Arity = 1
+	if err := Verify(script); err == nil {
+		t.Error("verified a script with parameters")
+	}
+}
+
+// TestVerifyUnnamedFunction checks that a bytecode file with a function
+// that has no name, which the VM would take for the script, is rejected.
+func TestVerifyUnnamedFunction(t *testing.T) {
+	inner := assemble([]byte{OpNull, OpReturn})
+	inner.Arity = 1
+	inner.ParamTypes = []*chunk.Type{chunk.IntType}
+	script := assemble([]byte{OpClosure, 0, OpConstant, 1, OpCall, 1, OpPop, OpNull, OpReturn},
+		chunk.Value{Type: chunk.TypeFunction, Value: inner},
+		chunk.Value{Type: chunk.TypeString, Value: chunk.NewGString("x")})
+
+	var file bytes.Buffer
+	if err := chunk.WriteBytecode(&file, script); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := chunk.ReadBytecode(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewVM(Options{}).Run(context.Background(), loaded)
+	var verifyErr *VerifyError
+	if result != InterpretCompileError || !errors.As(err, &verifyErr) || verifyErr.Message != "constant 0 is a function without a name" {
+		t.Errorf("got %d, %v", result, err)
+	}
+}
+
+func TestRunRejectsInvalidBytecode(t *testing.T) {
+	vm := NewVM(Options{})
+	result, err := vm.Run(context.Background(), assemble([]byte{OpGetLocal, 9, OpPrint, OpNull, OpReturn}))
+	var verifyErr *VerifyError
+	if result != InterpretCompileError || !errors.As(err, &verifyErr) || verifyErr.Offset != 0 {
+		t.Fatalf("got %d, %v", result, err)
+	}
+
+	// Code that passes the verifier but uses values of the wrong type fails
+	// with a runtime error.
+	name := chunk.Value{Type: chunk.TypeString, Value: chunk.NewGString("x")}
+	for _, test := range []struct {
+		fn   *chunk.GFunction
+		want string
+	}{
+		{assemble([]byte{OpNull, OpNull, OpField, 0, OpReturn}, name), "Only classes have fields."},
+		{assemble([]byte{OpNull, OpNull, OpMethod, 0, OpReturn}, name), "Only classes have methods."},
+		{assemble([]byte{OpClass, 0, OpNull, OpMethod, 0, OpReturn}, name), "Methods must be functions."},
+		{assemble([]byte{OpClass, 0, OpNull, OpInherit, OpReturn}, name), "Only classes can inherit."},
+		{assemble([]byte{OpClass, 0, OpGetLocal, 1, OpInherit, OpReturn}, name), "A class can't inherit from itself."},
+		{assemble([]byte{OpNull, OpNull, OpGetSuper, 0, OpReturn}, name), "Superclass must be a class."},
+	} {
+		result, err := vm.Run(context.Background(), test.fn)
+		var runtimeErr *RuntimeError
+		if result != InterpretRuntimeError || !errors.As(err, &runtimeErr) || runtimeErr.Kind != KindType || runtimeErr.Message != test.want {
+			t.Errorf("%v: got %d, %v, want %q", test.fn.Chunk.Code, result, err, test.want)
+		}
+	}
+
+	// The VM is still usable.
+	if result, err := vm.Interpret(context.Background(), []byte("var x = 1\n")); result != InterpretOk {
+		t.Errorf("got %d, %v", result, err)
+	}
+}
+
+func TestVerifyCompiledStack(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("fonction f() {\n")
+	for i := 1; i <= 254; i++ {
+		fmt.Fprintf(&b, "\tvar v%d = %d;\n", i, i)
+	}
+	b.WriteString("\tafficher v1 + v2 * v3;\n}\nf();\n")
+	fn, diagnostics := Compile([]byte(b.String()))
+	if diagnostics.HasErrors() {
+		t.Fatalf("got diagnostics %v", diagnostics)
+	}
+	if err := Verify(fn); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	deep := "var x = 1;\nafficher " + strings.Repeat("x + (", FrameSlots) + "x" + strings.Repeat(")", FrameSlots) + ";\n"
+	_, diagnostics = Compile([]byte(deep))
+	if len(diagnostics) != 1 || diagnostics[0].Message != "Too many values on the stack." {
+		t.Errorf("got diagnostics %v", diagnostics)
+	}
+}
@@ -2,17 +2,28 @@ package compiler
 
 import (
 	"GNBS/chunk"
+	"context"
 	"fmt"
 	"math"
 	"os"
+	"sync"
 )
 
 const (
 	FrameMax = 64
-	StackMax = (math.MaxUint8 + 1) * FrameMax
+	// FrameSlots is the number of stack slots a call can use: up to 256
+	// locals and as many temporary values.
+	FrameSlots = 2 * (math.MaxUint8 + 1)
+	StackMax   = FrameSlots * FrameMax
 )
 
+// VM runs compiled GNBS code. Each VM has its own globals and stack, and
+// runs one source at a time: concurrent calls to Interpret on the same VM
+// wait for each other.
 type VM struct {
+	opts Options
+	mu   sync.Mutex
+
 	Frames     []CallFrame
 	FrameCount int
 
@@ -21,24 +32,46 @@ type VM struct {
 
 	stringsTable *chunk.Table
 	globals      *chunk.Table
+	globalTypes  map[string]*chunk.Type
+	initString   *chunk.GString
+
+	// err is the error that stopped the last program, if any.
+	err *RuntimeError
+
+	// openUpvalues are the upvalues still pointing into the stack, sorted
+	// from the highest slot down.
+	openUpvalues *chunk.GUpvalue
 }
 
 type CallFrame struct {
+	Closure  *chunk.GClosure
 	Function *chunk.GFunction
-	Ip       uint16
+	Ip       int
 	Code     []byte
 	Slots    []chunk.Value
+	Base     int
 }
 
-var vm *VM
+func NewVM(opts Options) *VM {
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	globalTypes := make(map[string]*chunk.Type)
+	for name, t := range opts.Globals {
+		globalTypes[name] = t
+	}
+	opts.Globals = globalTypes
 
-func InitVM(ck *chunk.Chunk) {
-	vm = &VM{
+	return &VM{
+		opts:         opts,
+		Frames:       make([]CallFrame, FrameMax),
 		FrameCount:   0,
 		stackTop:     0,
 		stack:        make([]chunk.Value, StackMax),
 		stringsTable: chunk.NewTable(),
 		globals:      chunk.NewTable(),
+		globalTypes:  globalTypes,
+		initString:   chunk.NewGString(initializerName),
 	}
 }
 
@@ -50,25 +83,75 @@ const (
 	InterpretRuntimeError
 )
 
-func (v *VM) interpret(source []byte) InterpretResult {
-	fn := Compile(source)
+// Interpret compiles source with the options of the VM and runs it. The
+// globals it defines stay in the VM for the next sources. Cancelling ctx
+// stops the program with a runtime error at its next loop or call.
+//
+// The error is the Diagnostics of the compiler if source does not compile
+// and a *RuntimeError if the program fails.
+func (vm *VM) Interpret(ctx context.Context, source []byte) (InterpretResult, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	fn, diagnostics := New(vm.opts).Compile(source)
 	if fn == nil {
-		return InterpretCompileError
+		return InterpretCompileError, diagnostics
+	}
+	return vm.execute(ctx, fn)
+}
+
+// Run runs a script compiled beforehand, such as one read from a bytecode
+// file, like Interpret runs the script it compiles. The script is checked
+// with Verify first: if it fails, Run returns InterpretCompileError and
+// the *VerifyError.
+func (vm *VM) Run(ctx context.Context, fn *chunk.GFunction) (InterpretResult, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if err := Verify(fn); err != nil {
+		return InterpretCompileError, err
 	}
-	push(chunk.Value{
-		Type:  chunk.TypeFunction,
-		Value: fn,
+	return vm.execute(ctx, fn)
+}
+
+func (vm *VM) execute(ctx context.Context, fn *chunk.GFunction) (InterpretResult, error) {
+	vm.startScript(fn)
+	if result := vm.run(ctx); result != InterpretOk {
+		return result, vm.takeError()
+	}
+	vm.pop()
+	return InterpretOk, nil
+}
+
+// evaluate runs fn, a script that neither calls functions nor uses
+// variables, and returns its result, or false if it fails.
+func (vm *VM) evaluate(fn *chunk.GFunction) (chunk.Value, bool) {
+	vm.startScript(fn)
+	if vm.run(context.Background()) != InterpretOk {
+		vm.takeError()
+		return chunk.Value{}, false
+	}
+	return vm.pop(), true
+}
+
+// startScript calls the script fn, to be run by run.
+func (vm *VM) startScript(fn *chunk.GFunction) {
+	closure := chunk.NewGClosure(fn)
+	vm.push(chunk.Value{
+		Type:  chunk.TypeClosure,
+		Value: closure,
 	})
 	frame := &vm.Frames[vm.FrameCount]
 	vm.FrameCount++
 	frame.Ip = 0
+	frame.Closure = closure
+	frame.Function = fn
 	frame.Code = fn.Chunk.Code
 	frame.Slots = vm.stack
-
-	return run()
+	frame.Base = 0
 }
 
-func run() InterpretResult {
+func (vm *VM) run(ctx context.Context) InterpretResult {
 	frame := &vm.Frames[vm.FrameCount-1]
 
 	for {
@@ -76,143 +159,293 @@ func run() InterpretResult {
 
 		switch instruction {
 		case OpReturn:
-			result := pop()
+			result := vm.pop()
+			vm.closeUpvalues(frame.Base)
 			vm.FrameCount--
+			vm.stackTop = frame.Base
+			vm.push(result)
 			if vm.FrameCount == 0 {
-				pop()
 				return InterpretOk
 			}
-			vm.stackTop -= len(frame.Slots)
-			push(result)
 			frame = &vm.Frames[vm.FrameCount-1]
 			break
-		case OpConstant:
-			constant := readConstant(frame)
-			push(constant)
+		case OpConstant, OpConstantLong:
+			constant := readConstant(frame, instruction)
+			vm.push(constant)
 			break
 		case OpNull:
-			push(chunk.Value{
+			vm.push(chunk.Value{
 				Type:  chunk.TypeNull,
 				Value: nil,
 			})
 			break
 		case OpNot:
-			val := pop()
-			push(chunk.Value{
+			if !vm.checkBoolean(vm.peek(0)) {
+				return InterpretRuntimeError
+			}
+			val := vm.pop()
+			vm.push(chunk.Value{
 				Type:  chunk.TypeBool,
 				Value: !val.Bool(),
 			})
 			break
 		case OpTrue:
-			push(chunk.Value{
-				Type:  chunk.TypeNull,
+			vm.push(chunk.Value{
+				Type:  chunk.TypeBool,
 				Value: true,
 			})
 			break
 		case OpFalse:
-			push(chunk.Value{
-				Type:  chunk.TypeNull,
+			vm.push(chunk.Value{
+				Type:  chunk.TypeBool,
 				Value: false,
 			})
 			break
 		case OpEqual:
-			val, val2 := pop(), pop()
-			push(chunk.Value{
+			val, val2 := vm.pop(), vm.pop()
+			vm.push(chunk.Value{
 				Type:  chunk.TypeBool,
-				Value: val.Value == val2.Value,
+				Value: chunk.ValuesEqual(val, val2),
 			})
 			break
 		case OpNegate:
-			if typ := peek(0); typ.Type != chunk.TypeInteger && typ.Type != chunk.TypeFloat {
-				runtimeError("Operand must be a number.")
+			if typ := vm.peek(0); typ.Type != chunk.TypeInteger && typ.Type != chunk.TypeFloat {
+				vm.runtimeError(KindType, "Operand must be a number.")
 				return InterpretRuntimeError
 			}
 
-			val := pop()
+			val := vm.pop()
 			if val.Type == chunk.TypeInteger {
 				val.Value = -val.Integer()
 			} else {
 				val.Value = -val.Float()
 			}
-			push(val)
+			vm.push(val)
 			break
 
 		case OpPrint:
-			chunk.PrintValue(pop())
-			fmt.Println()
+			chunk.FprintValue(vm.opts.Stdout, vm.pop())
+			fmt.Fprintln(vm.opts.Stdout)
 			break
 
 		case OpPop:
-			pop()
+			vm.pop()
 			break
 
-		case OpDefineGlobal:
-			name := readString(frame)
-			vm.globals.TableSet(name, peek(0))
-			pop()
+		case OpDefineGlobal, OpDefineGlobalLong:
+			name := readString(frame, instruction)
+			vm.globals.TableSet(name, vm.peek(0))
+			vm.pop()
 			break
 
 		case OpGetLocal:
 			slot := readByte(frame)
-			push(frame.Slots[slot])
+			vm.push(frame.Slots[slot])
 			break
 
-		case OpGetGlobal:
-			name := readString(frame)
+		case OpGetGlobal, OpGetGlobalLong:
+			name := readString(frame, instruction)
 			var value chunk.Value
 
-			if vm.globals.TableGet(name, &value) {
-				runtimeError("Undefined variable '%s'.", name.String)
+			if !vm.globals.TableGet(name, &value) {
+				vm.runtimeError(KindName, "Undefined variable '%s'.", name.String)
 				return InterpretRuntimeError
 			}
-			push(value)
+			vm.push(value)
 			break
 
 		case OpSetLocal:
 			slot := readByte(frame)
-			frame.Slots[slot] = peek(0)
+			frame.Slots[slot] = vm.peek(0)
 			break
 
-		case OpSetGlobal:
-			name := readString(frame)
-			if vm.globals.TableSet(name, peek(0)) {
+		case OpSetGlobal, OpSetGlobalLong:
+			name := readString(frame, instruction)
+			if vm.globals.TableSet(name, vm.peek(0)) {
 				vm.globals.TableDelete(name)
-				runtimeError("Undefined variable '%s'.", name.String)
+				vm.runtimeError(KindName, "Undefined variable '%s'.", name.String)
 				return InterpretRuntimeError
 			}
 			break
 
 		case OpJump:
 			offset := readShort(frame)
-			frame.Ip += offset
+			frame.Ip += int(offset)
 			break
 
 		case OpJumpIfFalse:
 			offset := readShort(frame)
-			if isFalsey(peek(0)) {
-				frame.Ip += offset
+			condition := vm.peek(0)
+			if !vm.checkBoolean(condition) {
+				return InterpretRuntimeError
+			}
+			if !condition.Bool() {
+				frame.Ip += int(offset)
 			}
 			break
 
 		case OpLoop:
 			offset := readShort(frame)
-			frame.Ip -= offset
+			if !vm.checkContext(ctx) {
+				return InterpretRuntimeError
+			}
+			frame.Ip -= int(offset)
 			break
 
 		case OpCall:
 			argCount := readByte(frame)
-			if !callValue(peek(argCount), argCount) {
+			if !vm.checkContext(ctx) {
+				return InterpretRuntimeError
+			}
+			if !vm.callValue(vm.peek(argCount), argCount) {
 				return InterpretRuntimeError
 			}
 			frame = &vm.Frames[vm.FrameCount-1]
 			break
 
-		case OpAdd, OpSubtract, OpMultiply, OpDivide:
-			result := binaryOperation(instruction)
-			if result != InterpretOk {
-				runtimeError("Operands must be numbers of the same type")
+		case OpClass, OpClassLong:
+			vm.push(chunk.Value{
+				Type:  chunk.TypeClass,
+				Value: chunk.NewGClass(readString(frame, instruction)),
+			})
+			break
+
+		case OpField, OpFieldLong:
+			class, ok := vm.peek(1).Value.(*chunk.GClass)
+			if !ok {
+				vm.runtimeError(KindType, "Only classes have fields.")
+				return InterpretRuntimeError
+			}
+			class.Fields.TableSet(readString(frame, instruction), vm.peek(0))
+			vm.pop()
+			break
+
+		case OpMethod, OpMethodLong:
+			class, ok := vm.peek(1).Value.(*chunk.GClass)
+			if !ok {
+				vm.runtimeError(KindType, "Only classes have methods.")
+				return InterpretRuntimeError
+			}
+			if _, ok := vm.peek(0).Value.(*chunk.GClosure); !ok {
+				vm.runtimeError(KindType, "Methods must be functions.")
+				return InterpretRuntimeError
+			}
+			class.Methods.TableSet(readString(frame, instruction), vm.peek(0))
+			vm.pop()
+			break
+
+		case OpGetProperty, OpGetPropertyLong:
+			if vm.peek(0).Type != chunk.TypeInstance {
+				vm.runtimeError(KindType, "Only instances have properties.")
+				return InterpretRuntimeError
+			}
+			instance, _ := vm.peek(0).Value.(*chunk.GInstance)
+			name := readString(frame, instruction)
+
+			var value chunk.Value
+			if instance.Fields.TableGet(name, &value) {
+				vm.pop()
+				vm.push(value)
+				break
+			}
+			if !vm.bindMethod(instance.Class, name) {
+				return InterpretRuntimeError
+			}
+			break
+
+		case OpSetProperty, OpSetPropertyLong:
+			if vm.peek(1).Type != chunk.TypeInstance {
+				vm.runtimeError(KindType, "Only instances have fields.")
+				return InterpretRuntimeError
+			}
+			instance, _ := vm.peek(1).Value.(*chunk.GInstance)
+			name := readString(frame, instruction)
+
+			if instance.Fields.TableSet(name, vm.peek(0)) {
+				instance.Fields.TableDelete(name)
+				vm.runtimeError(KindName, "Undefined field '%s'.", name.String)
+				return InterpretRuntimeError
+			}
+			value := vm.pop()
+			vm.pop()
+			vm.push(value)
+			break
+
+		case OpClosure, OpClosureLong:
+			fn, _ := readConstant(frame, instruction).Value.(*chunk.GFunction)
+			closure := chunk.NewGClosure(fn)
+			vm.push(chunk.Value{
+				Type:  chunk.TypeClosure,
+				Value: closure,
+			})
+
+			for i := range closure.Upvalues {
+				isLocal, index := readByte(frame), readByte(frame)
+				if isLocal == 1 {
+					closure.Upvalues[i] = vm.captureUpvalue(frame.Base + int(index))
+				} else {
+					closure.Upvalues[i] = frame.Closure.Upvalues[index]
+				}
+			}
+			break
+
+		case OpGetUpvalue:
+			slot := readByte(frame)
+			vm.push(*frame.Closure.Upvalues[slot].Location)
+			break
+
+		case OpSetUpvalue:
+			slot := readByte(frame)
+			*frame.Closure.Upvalues[slot].Location = vm.peek(0)
+			break
+
+		case OpCloseUpvalue:
+			vm.closeUpvalues(vm.stackTop - 1)
+			vm.pop()
+			break
+
+		case OpInherit:
+			superclass, ok := vm.peek(1).Value.(*chunk.GClass)
+			if !ok {
+				vm.runtimeError(KindType, "Superclass must be a class.")
 				return InterpretRuntimeError
 			}
+			subclass, ok := vm.peek(0).Value.(*chunk.GClass)
+			if !ok {
+				vm.runtimeError(KindType, "Only classes can inherit.")
+				return InterpretRuntimeError
+			}
+			// The compiler only lets a new class inherit from one declared
+			// before, but bytecode from a file could close a cycle, which
+			// would make looking at the superclasses loop forever.
+			for class := superclass; class != nil; class = class.Superclass {
+				if class == subclass {
+					vm.runtimeError(KindType, "A class can't inherit from itself.")
+					return InterpretRuntimeError
+				}
+			}
+			subclass.Superclass = superclass
+			chunk.TableAddAll(superclass.Fields, subclass.Fields)
+			chunk.TableAddAll(superclass.Methods, subclass.Methods)
+			vm.pop()
+			break
+
+		case OpGetSuper, OpGetSuperLong:
+			name := readString(frame, instruction)
+			superclass, ok := vm.pop().Value.(*chunk.GClass)
+			if !ok {
+				vm.runtimeError(KindType, "Superclass must be a class.")
+				return InterpretRuntimeError
+			}
+			if !vm.bindMethod(superclass, name) {
+				return InterpretRuntimeError
+			}
+			break
+
+		case OpAdd, OpSubtract, OpMultiply, OpDivide, OpModulo, OpFloorDivide, OpPower, OpGreater, OpLess:
+			if result := vm.binaryOperation(instruction); result != InterpretOk {
+				return result
+			}
 			break
 		}
 	}
@@ -223,13 +456,19 @@ func readByte(frame *CallFrame) byte {
 	return frame.Code[frame.Ip-1]
 }
 
-func readConstant(frame *CallFrame) chunk.Value {
-	index := readByte(frame)
-	return frame.Slots[index]
+// readConstant reads the index operand of instruction, on three bytes for
+// a long instruction, and returns the constant it designates.
+func readConstant(frame *CallFrame, instruction byte) chunk.Value {
+	if !isLong(instruction) {
+		return frame.Function.Chunk.Values[readByte(frame)]
+	}
+	frame.Ip += 3
+	index := int(frame.Code[frame.Ip-3])<<16 | int(frame.Code[frame.Ip-2])<<8 | int(frame.Code[frame.Ip-1])
+	return frame.Function.Chunk.Values[index]
 }
 
-func readString(frame *CallFrame) *chunk.GString {
-	value := readConstant(frame)
+func readString(frame *CallFrame, instruction byte) *chunk.GString {
+	value := readConstant(frame, instruction)
 	return value.Value.(*chunk.GString)
 }
 
@@ -238,107 +477,209 @@ func readShort(frame *CallFrame) uint16 {
 	return uint16(frame.Code[frame.Ip-2])<<8 | uint16(frame.Code[frame.Ip-1])
 }
 
-func resetStack() {
+func (vm *VM) resetStack() {
 	vm.stackTop = 0
-	vm.stack = []chunk.Value{}
+	vm.FrameCount = 0
+	vm.openUpvalues = nil
 }
 
-func pop() chunk.Value {
+func (vm *VM) pop() chunk.Value {
 	vm.stackTop--
 	return vm.stack[vm.stackTop]
 }
 
-func push(value chunk.Value) {
+func (vm *VM) push(value chunk.Value) {
 	vm.stackTop++
 	vm.stack[vm.stackTop-1] = value
 }
 
-func peek(distance byte) chunk.Value {
+func (vm *VM) peek(distance byte) chunk.Value {
 	return vm.stack[vm.stackTop-1-int(distance)]
 }
 
-func call(fn *chunk.GFunction, argCount byte) bool {
+func (vm *VM) call(closure *chunk.GClosure, argCount byte) bool {
+	fn := closure.Function
 	if int(argCount) != fn.Arity {
-		runtimeError("Expected %d arguments but got %d.", fn.Arity, argCount)
+		vm.runtimeError(KindArity, "Expected %d arguments but got %d.", fn.Arity, argCount)
 		return false
 	}
 
 	if vm.FrameCount == FrameMax {
-		runtimeError("Stack overflow.")
+		vm.runtimeError(KindStackOverflow, "Stack overflow.")
+		return false
+	}
+
+	args := vm.stack[vm.stackTop-int(argCount) : vm.stackTop]
+	if !vm.checkArguments(functionName(fn), fn.ParamTypes, args) {
 		return false
 	}
 
 	frame := &vm.Frames[vm.FrameCount]
 	vm.FrameCount++
-	frame.Ip, frame.Function, frame.Code = 0, fn, fn.Chunk.Code
+	frame.Ip, frame.Closure, frame.Function, frame.Code = 0, closure, fn, fn.Chunk.Code
 
-	frame.Slots = vm.stack[argCount-1:vm.stackTop]
+	frame.Base = vm.stackTop - int(argCount) - 1
+	frame.Slots = vm.stack[frame.Base:]
 	return true
 }
 
-func callValue(callee chunk.Value, argCount byte) bool {
+// checkArguments checks the arguments of a call against the types of the
+// parameters of the function, shown as name in errors, such as "f()".
+func (vm *VM) checkArguments(name string, params []*chunk.Type, args []chunk.Value) bool {
+	for i, param := range params {
+		if !param.IsAny() && !isOfType(args[i], param) {
+			vm.runtimeError(KindType, "Argument %d of %s must be %s, got %s.", i+1, name, param, args[i].StaticType())
+			return false
+		}
+	}
+	return true
+}
+
+// isOfType reports whether value can be bound to a parameter of type t.
+func isOfType(value chunk.Value, t *chunk.Type) bool {
+	return value.StaticType().AssignableByName(t)
+}
+
+func (vm *VM) callValue(callee chunk.Value, argCount byte) bool {
 	switch callee.Type {
-	case chunk.TypeFunction:
-		fn, _ := callee.Value.(*chunk.GFunction)
-		return call(fn, argCount)
-	case chunk.TypeNative:
-		fn, _ := callee.Value.(*chunk.GNative)
-		result := fn.Function(argCount, vm.stack[vm.stackTop-int(argCount):])
-		vm.stackTop -= int(argCount) + 1
-		push(result)
+	case chunk.TypeClosure:
+		closure, _ := callee.Value.(*chunk.GClosure)
+		return vm.call(closure, argCount)
+	case chunk.TypeClass:
+		class, _ := callee.Value.(*chunk.GClass)
+		vm.stack[vm.stackTop-int(argCount)-1] = chunk.Value{
+			Type:  chunk.TypeInstance,
+			Value: chunk.NewGInstance(class),
+		}
+
+		var initializer chunk.Value
+		if class.Methods.TableGet(vm.initString, &initializer) {
+			closure, _ := initializer.Value.(*chunk.GClosure)
+			return vm.call(closure, argCount)
+		} else if argCount != 0 {
+			vm.runtimeError(KindArity, "Expected 0 arguments but got %d.", argCount)
+			return false
+		}
 		return true
+	case chunk.TypeBoundMethod:
+		bound, _ := callee.Value.(*chunk.GBoundMethod)
+		vm.stack[vm.stackTop-int(argCount)-1] = bound.Receiver
+		return vm.call(bound.Method, argCount)
+	case chunk.TypeNative:
+		return vm.callNative(callee.Value.(*chunk.GNative), argCount)
 	default:
 		break
 	}
 
-	runtimeError("Can only call functions and classes.")
+	vm.runtimeError(KindType, "Can only call functions and classes.")
 	return false
 }
 
-func isFalsey(value chunk.Value) bool {
-	return value.Type == chunk.TypeNull || (value.Type == chunk.TypeBool && value.Bool())
-}
-
-// Errors
+// bindMethod replaces the instance on top of the stack by its method name.
+func (vm *VM) bindMethod(class *chunk.GClass, name *chunk.GString) bool {
+	var method chunk.Value
+	if !class.Methods.TableGet(name, &method) {
+		vm.runtimeError(KindName, "Undefined property '%s'.", name.String)
+		return false
+	}
 
-func runtimeError(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, format, args)
-	fmt.Fprintln(os.Stderr)
+	closure, _ := method.Value.(*chunk.GClosure)
+	bound := chunk.NewGBoundMethod(vm.peek(0), closure)
+	vm.pop()
+	vm.push(chunk.Value{
+		Type:  chunk.TypeBoundMethod,
+		Value: bound,
+	})
+	return true
+}
 
-	for i := vm.FrameCount - 1; i >= 0; i-- {
-		frame := &vm.Frames[i]
-		fn := frame.Function
+// captureUpvalue returns the open upvalue for the stack slot, creating it
+// if no closure captured that slot yet.
+func (vm *VM) captureUpvalue(slot int) *chunk.GUpvalue {
+	var prev *chunk.GUpvalue
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.Slot > slot {
+		prev = upvalue
+		upvalue = upvalue.Next
+	}
+	if upvalue != nil && upvalue.Slot == slot {
+		return upvalue
+	}
 
-		instruction := frame.Code[frame.Ip-1]
-		pos := frame.Function.Chunk.Pos[instruction]
-		fmt.Fprintf(os.Stderr, "[line %4d:%3d] in %s\n", pos.Line, pos.Column, pos.Filename)
+	created := chunk.NewGUpvalue(&vm.stack[slot], slot)
+	created.Next = upvalue
+	if prev == nil {
+		vm.openUpvalues = created
+	} else {
+		prev.Next = created
+	}
+	return created
+}
 
-		if fn.Name == nil {
-			fmt.Fprintf(os.Stderr, "script\n")
-		} else {
-			fmt.Fprintf(os.Stderr, "%s()\n", fn.Name.String)
-		}
+// closeUpvalues moves the variables at or above the stack slot last out of
+// the stack, into the upvalues that captured them.
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.Slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.Closed = *upvalue.Location
+		upvalue.Location = &upvalue.Closed
+		vm.openUpvalues = upvalue.Next
+	}
+}
 
+// checkBoolean reports a runtime error if value, the operand of a logical
+// operator or a condition, is not a boolean.
+func (vm *VM) checkBoolean(value chunk.Value) bool {
+	if value.Type != chunk.TypeBool {
+		vm.runtimeError(KindType, "Expected a boolean, got %s.", value.StaticType())
+		return false
 	}
+	return true
+}
 
+// checkContext reports a runtime error if ctx is done.
+func (vm *VM) checkContext(ctx context.Context) bool {
+	if err := ctx.Err(); err != nil {
+		vm.runtimeError(KindInterrupted, "Interrupted: %v.", err)
+		return false
+	}
+	return true
+}
 
+// Errors
 
-	resetStack()
+// takeError returns the error of the last program and clears it.
+func (vm *VM) takeError() *RuntimeError {
+	err := vm.err
+	vm.err = nil
+	return err
 }
 
-// Native Values
+// runtimeError stops the program with an error of the given kind, recording
+// the calls active at the time.
+func (vm *VM) runtimeError(kind ErrorKind, format string, args ...interface{}) {
+	err := &RuntimeError{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	}
+	for i := vm.FrameCount - 1; i >= 0; i-- {
+		frame := &vm.Frames[i]
+		fn := frame.Function
+		err.Frames = append(err.Frames, StackFrame{
+			Function: functionName(fn),
+			Pos:      fn.Chunk.Position(frame.Ip - 1),
+		})
+	}
+	vm.err = err
 
-func defineNative(name string, function chunk.NativeFn) {
-	push(chunk.Value{
-		Type:  chunk.TypeString,
-		Value: chunk.NewGString(name),
-	})
-	push(chunk.Value{
-		Type:  chunk.TypeNative,
-		Value: chunk.NewGNative(function),
-	})
-	str, _ := vm.stack[0].Value.(*chunk.GString)
-	vm.globals.TableSet(str, vm.stack[1])
-	pop()
-	pop()
+	vm.resetStack()
+}
+
+// functionName returns the name of fn in error messages: "script" for the
+// top level, f() for the function f.
+func functionName(fn *chunk.GFunction) string {
+	if fn.Name == nil {
+		return "script"
+	}
+	return fn.Name.String + "()"
 }
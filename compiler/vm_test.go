@@ -1 +1,1140 @@
 package compiler
+
+import (
+	"GNBS/chunk"
+	"GNBS/token"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func interpretSource(t *testing.T, src string) (*VM, InterpretResult) {
+	t.Helper()
+	vm := NewVM(Options{})
+	result, _ := vm.Interpret(context.Background(), []byte(src))
+	return vm, result
+}
+
+func globalValue(t *testing.T, vm *VM, name string) chunk.Value {
+	t.Helper()
+	var value chunk.Value
+	if !vm.globals.TableGet(chunk.NewGString(name), &value) {
+		t.Fatalf("global %q is not defined", name)
+	}
+	return value
+}
+
+func expectGlobals(t *testing.T, src string, want map[string]interface{}) *VM {
+	t.Helper()
+	vm, result := interpretSource(t, src)
+	if result != InterpretOk {
+		t.Fatalf("%q: got result %d", src, result)
+	}
+	for name, value := range want {
+		if got := globalValue(t, vm, name); got.Value != value {
+			if got.Type != chunk.TypeString || got.String() != value {
+				t.Errorf("%q: %s = %v, want %v", src, name, got.Value, value)
+			}
+		}
+	}
+	return vm
+}
+
+func TestTypedSignature(t *testing.T) {
+	src := `
+fonction somme(a: Ent, b: Ent) -> Ent {
+	revenir a + b
+}
+fonction moitie(x: Flot) -> Flot {
+	revenir x / 2.0
+}
+fonction salut(nom: Cha) -> Cha {
+	revenir "salut " + nom
+}
+var s = somme(40, 2)
+var m = moitie(3.0)
+var n = salut("toi")
+`
+	vm := expectGlobals(t, src, map[string]interface{}{
+		"s": int64(42),
+		"m": 1.5,
+		"n": "salut toi",
+	})
+
+	fn := globalValue(t, vm, "somme")
+	if got := fn.StaticType().String(); got != "fonction(Ent, Ent) -> Ent" {
+		t.Errorf("somme has signature %s", got)
+	}
+}
+
+func TestTypedSignatureErrors(t *testing.T) {
+	tests := map[string]string{
+		"fonction f(a: Ent) -> Ent {\n\trevenir \"a\"\n}\n":     "cannot use Cha as Ent in return statement",
+		"fonction f(a: Ent) -> Ent {\n\trevenir a\n}\nf(1.0)\n": "cannot use Flot as Ent in argument 1",
+		"fonction f(a: Cha) {\n\tvar x = a * 2\n}\n":            "mismatched types Cha and Ent for '*'",
+		"fonction f() -> Ent {\n\trevenir\n}\n":                 "cannot use nul as Ent in return statement",
+	}
+
+	for src, want := range tests {
+		if errs := typeErrors(t, src); len(errs) != 1 || errs[0] != want {
+			t.Errorf("%q: got %q, want %q", src, errs, want)
+		}
+	}
+}
+
+func TestRuntimeArgumentCheck(t *testing.T) {
+	src := `
+fonction somme(a: Ent, b: Ent) -> Ent {
+	revenir a + b
+}
+var f = nul
+f = somme
+var ok = f(1, 2)
+f(1, "deux")
+`
+	vm, result := interpretSource(t, src)
+	if result != InterpretRuntimeError {
+		t.Fatalf("got result %d, want a runtime error", result)
+	}
+	if got := globalValue(t, vm, "ok"); got.Integer() != 3 {
+		t.Errorf("ok = %v", got.Value)
+	}
+}
+
+func TestDeclarations(t *testing.T) {
+	src := `
+var a: Ent
+var f: Flot
+var s: Cha
+var b: Bool
+var e: Entier = 7
+var x = 10
+y := x * 2
+nom := "GNBS"
+
+fonction locales() -> Ent {
+	var z: Ent
+	w := 5
+	var v: Ent = 3
+	revenir z + w + v
+}
+l := locales()
+`
+	expectGlobals(t, src, map[string]interface{}{
+		"a":   int64(0),
+		"f":   0.0,
+		"s":   "",
+		"b":   false,
+		"e":   int64(7),
+		"x":   int64(10),
+		"y":   int64(20),
+		"nom": "GNBS",
+		"l":   int64(8),
+	})
+}
+
+func TestForLoops(t *testing.T) {
+	src := `
+somme := 0
+pendant i := 0; i < 10; i++ {
+	somme = somme + i
+}
+
+compte := 0
+pendant i := 10; i > 0; i-- {
+	double := i * 2
+	compte = compte + double
+}
+
+n := 1
+pendant n < 100 {
+	n = n * 2
+}
+
+k := 0
+pendant ; k < 3; {
+	k++
+}
+
+fonction premier() -> Ent {
+	i := 0
+	pendant {
+		i++
+		si i == 5 {
+			revenir i
+		}
+	}
+}
+p := premier()
+
+f := 0.5
+f++
+`
+	expectGlobals(t, src, map[string]interface{}{
+		"somme":  int64(45),
+		"compte": int64(110),
+		"n":      int64(128),
+		"k":      int64(3),
+		"p":      int64(5),
+		"f":      1.5,
+	})
+}
+
+func TestForLoopErrors(t *testing.T) {
+	tests := map[string]string{
+		"pendant 1 {\n}\n":              "non-boolean condition (Ent)",
+		"s := \"a\"\ns++\n":             "operator '++' not defined on Cha",
+		"pendant i := 0; i; i++ {\n}\n": "non-boolean condition (Ent)",
+	}
+
+	for src, want := range tests {
+		if errs := typeErrors(t, src); len(errs) != 1 || errs[0] != want {
+			t.Errorf("%q: got %q, want %q", src, errs, want)
+		}
+	}
+}
+
+func TestIfChains(t *testing.T) {
+	src := `
+fonction signe(n: Ent) -> Cha {
+	si n < 0 {
+		revenir "négatif"
+	} sinon si n == 0 {
+		revenir "nul"
+	}
+	sinon si n < 10 {
+		revenir "petit"
+	} autre {
+		revenir "grand"
+	}
+}
+a := signe(-3)
+b := signe(0)
+c := signe(4)
+d := signe(40)
+
+x := 1
+{
+	x := 2
+	x = x + 1
+}
+
+y := 0
+si x == 1 {
+	y = 1
+}
+si x == 2 {
+	y = 2
+}
+autre {
+	z := 3
+	y = y + z
+}
+
+fonction absolu(n: Ent) -> Ent { si n < 0 { revenir -n } revenir n }
+e := absolu(-5)
+
+classe Boite {
+	n: Ent
+	init(n: Ent) { ceci.n = n }
+}
+si vrai { y = y + Boite(1).n }
+`
+	expectGlobals(t, src, map[string]interface{}{
+		"a": "négatif",
+		"b": "nul",
+		"c": "petit",
+		"d": "grand",
+		"e": int64(5),
+		"x": int64(1),
+		"y": int64(5),
+	})
+}
+
+func TestClasses(t *testing.T) {
+	src := `
+classe Point {
+	x: Ent
+	y: Ent
+
+	init(x: Ent, y: Ent) {
+		ceci.x = x
+		ceci.y = y
+	}
+
+	somme() -> Ent {
+		revenir ceci.x + ceci.y
+	}
+
+	deplacer(dx: Ent) {
+		ceci.x = ceci.x + dx
+	}
+}
+
+p := Point(1, 2)
+p.deplacer(10)
+s := p.somme()
+px := p.x
+m := p.somme
+ms := m()
+
+classe Compteur {
+	n: Ent
+	nom: Cha
+	suivant: Compteur
+
+	incr() -> Compteur {
+		ceci.n = ceci.n + 1
+		revenir ceci
+	}
+}
+
+c := Compteur()
+c.incr().incr()
+n := c.n
+nom := c.nom
+vide := c.suivant == nul
+`
+	expectGlobals(t, src, map[string]interface{}{
+		"s":    int64(13),
+		"px":   int64(11),
+		"ms":   int64(13),
+		"n":    int64(2),
+		"nom":  "",
+		"vide": true,
+	})
+}
+
+func TestClassErrors(t *testing.T) {
+	class := "classe Point {\n\tx: Ent\n\tinit(x: Ent) {\n\t\tceci.x = x\n\t}\n\tlire() -> Ent {\n\t\trevenir ceci.x\n\t}\n}\n"
+	tests := map[string]string{
+		"p := Point(1)\np.z\n":                   "Point has no field or method z",
+		"p := Point(1)\np.x = \"a\"\n":           "cannot use Cha as Ent in assignment to x",
+		"p := Point()\n":                         "wrong number of arguments: expected 1, got 0",
+		"var q: Point = 1\n":                     "cannot use Ent as Point in declaration of q",
+		"p := Point(1)\np.lire = nul\n":          "cannot assign to method lire",
+		"p := Point(1)\nvar s: Cha = p.lire()\n": "cannot use Ent as Cha in declaration of s",
+		"x := 1\nx.y\n":                          "Ent has no field or method y",
+	}
+
+	for src, want := range tests {
+		if errs := typeErrors(t, class+src); len(errs) != 1 || errs[0] != want {
+			t.Errorf("%q: got %q, want %q", src, errs, want)
+		}
+	}
+
+	for _, src := range []string{
+		"afficher ceci\n",
+		"classe A {\n\tinit() {\n\t\trevenir 1\n\t}\n}\n",
+		"classe A {\n\tx: Ent\n\tx() {\n\t}\n}\n",
+	} {
+		if !hasSyntaxError(src) {
+			t.Errorf("%q: expected a compile error", src)
+		}
+	}
+}
+
+func TestUndefinedField(t *testing.T) {
+	src := `
+classe A {
+	x: Ent
+}
+fonction ecrire(o) {
+	o.y = 1
+}
+ecrire(A())
+`
+	if _, result := interpretSource(t, src); result != InterpretRuntimeError {
+		t.Fatalf("got result %d, want a runtime error", result)
+	}
+}
+
+func TestInheritance(t *testing.T) {
+	src := `
+classe Animal {
+	nom: Cha
+	pattes: Ent
+
+	init(nom: Cha) {
+		ceci.nom = nom
+		ceci.pattes = 4
+	}
+
+	parler() -> Cha {
+		revenir "..."
+	}
+
+	presenter() -> Cha {
+		revenir ceci.nom + " dit " + ceci.parler()
+	}
+}
+
+classe Chien < Animal {
+	parler() -> Cha {
+		revenir "ouaf"
+	}
+}
+
+classe Chiot < Chien {
+	init(nom: Cha) {
+		super.init(nom + " junior")
+	}
+
+	parler() -> Cha {
+		revenir super.parler() + " ouaf"
+	}
+}
+
+fonction nom(a: Animal) -> Cha {
+	revenir a.nom
+}
+
+var a: Animal = Chiot("Rex")
+p := a.presenter()
+n := nom(Chien("Médor"))
+pattes := a.pattes
+generique := Animal("Chat").presenter()
+`
+	expectGlobals(t, src, map[string]interface{}{
+		"p":         "Rex junior dit ouaf ouaf",
+		"n":         "Médor",
+		"pattes":    int64(4),
+		"generique": "Chat dit ...",
+	})
+}
+
+func TestInheritanceErrors(t *testing.T) {
+	animal := "classe Animal {\n\tnom: Cha\n\tparler() -> Cha {\n\t\trevenir \"...\"\n\t}\n}\n"
+	tests := map[string]string{
+		"classe Chien < Animal {\n\tparler() -> Ent {\n\t\trevenir 1\n\t}\n}\n": "cannot use fonction() -> Ent as fonction() -> Cha in override of parler",
+		"x := 1\nclasse Chien < x {\n}\n":                                       "cannot inherit from non-class Ent",
+		"classe Chien < Animal {\n\tf() {\n\t\tsuper.voler()\n\t}\n}\n":         "Animal has no method voler",
+		"classe Chien {\n}\nvar a: Animal = Chien()\n":                          "cannot use Chien as Animal in declaration of a",
+	}
+	for src, want := range tests {
+		if errs := typeErrors(t, animal+src); len(errs) != 1 || errs[0] != want {
+			t.Errorf("%q: got %q, want %q", src, errs, want)
+		}
+	}
+
+	for _, src := range []string{
+		"classe A < A {\n}\n",
+		"classe A {\n\tf() {\n\t\tsuper.f()\n\t}\n}\n",
+		animal + "classe Chien < Animal {\n\tnom: Cha\n}\n",
+	} {
+		if !hasSyntaxError(src) {
+			t.Errorf("%q: expected a compile error", src)
+		}
+	}
+}
+
+// TestRedeclaredClass checks that a class declared again under the same
+// name is a new class, which may inherit from one based on the old one.
+func TestRedeclaredClass(t *testing.T) {
+	src := `
+classe A {
+	nom() -> Cha {
+		revenir "A"
+	}
+}
+classe B < A {
+}
+classe A < B {
+	nom() -> Cha {
+		revenir "nouveau " + super.nom()
+	}
+}
+var a: A = A()
+var b: B = a
+n := b.nom()
+`
+	expectGlobals(t, src, map[string]interface{}{"n": "nouveau A"})
+
+	want := "cannot use B as A in declaration of x"
+	if errs := typeErrors(t, src+"var x: A = B()\n"); len(errs) != 1 || errs[0] != want {
+		t.Errorf("got %q, want %q", errs, want)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	src := `
+fonction compteur() -> fonction {
+	n := 0
+	fonction incr() -> Ent {
+		n++
+		revenir n
+	}
+	revenir incr
+}
+c1 := compteur()
+c2 := compteur()
+c1()
+c1()
+a := c1()
+b := c2()
+
+fonction appliquer(f: fonction, x: Ent) -> Ent {
+	revenir f(x)
+}
+fonction ajouteur(k: Ent) -> fonction {
+	fonction ajouter(x: Ent) -> Ent {
+		revenir x + k
+	}
+	revenir ajouter
+}
+s := appliquer(ajouteur(10), 5)
+
+var lire = nul
+var ecrire = nul
+fonction partage() {
+	v := "avant"
+	fonction l() -> Cha {
+		revenir v
+	}
+	fonction e(x: Cha) {
+		v = x
+	}
+	lire = l
+	ecrire = e
+}
+partage()
+ecrire("après")
+p := lire()
+
+total := 0
+fonction boucle() {
+	pendant i := 0; i < 3; i++ {
+		fonction ajouter() {
+			total = total + i
+		}
+		ajouter()
+	}
+}
+boucle()
+
+classe Bouton {
+	clics: Ent
+	rappel() -> fonction {
+		fonction clic() {
+			ceci.clics = ceci.clics + 1
+		}
+		revenir clic
+	}
+}
+bt := Bouton()
+r := bt.rappel()
+r()
+r()
+clics := bt.clics
+
+fonction fabrique() -> Cha {
+	classe A {
+		nom() -> Cha {
+			revenir "A"
+		}
+	}
+	classe B < A {
+		nom() -> Cha {
+			fonction parent() -> Cha {
+				revenir super.nom()
+			}
+			revenir parent() + "B"
+		}
+	}
+	revenir B().nom()
+}
+ab := fabrique()
+`
+	expectGlobals(t, src, map[string]interface{}{
+		"a":     int64(3),
+		"b":     int64(1),
+		"s":     int64(15),
+		"p":     "après",
+		"total": int64(3),
+		"clics": int64(2),
+		"ab":    "AB",
+	})
+}
+
+func TestUpvalueTypes(t *testing.T) {
+	src := "fonction f() {\n\tn := 0\n\tfonction g() {\n\t\tn = \"a\"\n\t}\n}\n"
+	if errs := typeErrors(t, src); len(errs) != 1 || errs[0] != "cannot use Cha as Ent in assignment to n" {
+		t.Errorf("got %q", errs)
+	}
+}
+
+func TestArithmeticOperators(t *testing.T) {
+	src := `
+var a = 7 mod 3
+var b = -7 mod 3
+var c = 7 mod -3
+var d = 7 div 2
+var e = -7 div 2
+var f = 7 / -2
+var g = 2 ** 10
+var h = 2 ** 3 ** 2
+var i = -2 ** 2
+var j = 2 ** -1
+var k = -1 ** -3
+var l = 1 + 2 * 3 mod 4
+var m = 7.5 mod 2.0
+var n = -7.5 mod 2.0
+var o = 7.5 div 2.0
+var p = 2.0 ** 0.5 ** 2.0
+var q = (2 + 1) ** 2
+`
+	expectGlobals(t, src, map[string]interface{}{
+		"a": int64(1),
+		"b": int64(2),
+		"c": int64(-2),
+		"d": int64(3),
+		"e": int64(-4),
+		"f": int64(-3),
+		"g": int64(1024),
+		"h": int64(512),
+		"i": int64(-4),
+		"j": int64(0),
+		"k": int64(-1),
+		"l": int64(3),
+		"m": 1.5,
+		"n": 0.5,
+		"o": 3.0,
+		"p": math.Pow(2, 0.25),
+		"q": int64(9),
+	})
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	for _, src := range []string{
+		"var z = 0\nvar x = 1 mod z\n",
+		"var z = 0\nvar x = 1 div z\n",
+		"var z = 0.0\nvar x = 1.0 mod z\n",
+		"var z = 0.0\nvar x = 1.0 div z\n",
+		"var z = 0.0\nvar x = 1.0 / z\n",
+		"var z = -0.0\nvar x = 0.0 / z\n",
+		"var z = 0\nvar x = z ** -1\n",
+	} {
+		_, err := NewVM(Options{}).Interpret(context.Background(), []byte(src))
+		if runtimeErr, ok := err.(*RuntimeError); !ok || runtimeErr.Kind != KindDivisionByZero {
+			t.Errorf("%q: got error %v, want a division by zero", src, err)
+		}
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	values := []string{"faux", "vrai"}
+	for _, a := range values {
+		for _, b := range values {
+			av, bv := a == "vrai", b == "vrai"
+			// The right operand only runs when it decides the result.
+			src := fmt.Sprintf(`
+var appels = 0
+fonction droite(v: Bool) -> Bool {
+	appels = appels + 1
+	revenir v
+}
+appels = 0
+var et1 = %[1]s et droite(%[2]s)
+var appelsEt = appels
+appels = 0
+var ou1 = %[1]s ou droite(%[2]s)
+var appelsOu = appels
+var np1 = np %[1]s
+var np2 = !%[1]s
+`, a, b)
+			callsAnd, callsOr := int64(0), int64(0)
+			if av {
+				callsAnd = 1
+			} else {
+				callsOr = 1
+			}
+			expectGlobals(t, src, map[string]interface{}{
+				"et1":      av && bv,
+				"ou1":      av || bv,
+				"appelsEt": callsAnd,
+				"appelsOu": callsOr,
+				"np1":      !av,
+				"np2":      !av,
+			})
+		}
+	}
+
+	expectGlobals(t, "//gnbs:locale en\nvar x = true and not false or false\n", map[string]interface{}{"x": true})
+	expectGlobals(t, "var x = faux et vrai ou vrai\nvar y = faux et (vrai ou vrai)\n", map[string]interface{}{"x": true, "y": false})
+}
+
+func TestLogicalOperatorTypes(t *testing.T) {
+	for _, src := range []string{
+		"var x = 1 et vrai\n",
+		"var x = vrai et 1\n",
+		"var x = faux ou \"a\"\n",
+		"var x = nul ou vrai\n",
+		"var x = np 1\n",
+		"var x = !\"a\"\n",
+	} {
+		if result, _ := NewVM(Options{}).Interpret(context.Background(), []byte(src)); result != InterpretCompileError {
+			t.Errorf("%q: got result %d, want a compile error", src, result)
+		}
+	}
+
+	untyped := `
+fonction et_(a, b) {
+	revenir a et b
+}
+fonction ou_(a, b) {
+	revenir a ou b
+}
+fonction np_(a) {
+	revenir np a
+}
+fonction si_(a) {
+	si a {
+		revenir 1
+	}
+	revenir 0
+}
+`
+	tests := []struct {
+		call string
+		ok   bool
+	}{
+		{"et_(vrai, vrai)", true},
+		{"et_(faux, 1)", true},
+		{"et_(1, vrai)", false},
+		{"et_(vrai, 1)", false},
+		{"ou_(vrai, 1)", true},
+		{"ou_(nul, vrai)", false},
+		{"ou_(faux, \"a\")", false},
+		{"np_(faux)", true},
+		{"np_(nul)", false},
+		{"si_(vrai)", true},
+		{"si_(0)", false},
+	}
+	for _, test := range tests {
+		_, err := NewVM(Options{}).Interpret(context.Background(), []byte(untyped+"var r = "+test.call+"\n"))
+		runtimeErr, isRuntime := err.(*RuntimeError)
+		if test.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", test.call, err)
+		} else if !test.ok && (!isRuntime || runtimeErr.Kind != KindType) {
+			t.Errorf("%s: got error %v, want a type error", test.call, err)
+		}
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	src := `
+somme := 0
+pendant i := 0; i < 10; i++ {
+	si i == 7 {
+		casser
+	}
+	carre := i * i
+	si carre / 2 * 2 != carre {
+		continuer
+	}
+	somme = somme + carre
+}
+
+paires := 0
+n := 0
+pendant n < 20 {
+	n++
+	x := n
+	si x / 2 * 2 != x {
+		continuer
+	}
+	paires++
+}
+
+lignes := 0
+pendant i := 0; i < 5; i++ {
+	pendant j := 0; ; j++ {
+		si j == i {
+			casser
+		}
+		lignes++
+	}
+	si i == 3 {
+		casser
+	}
+}
+
+var rappels = nul
+pendant i := 0; i < 3; i++ {
+	v := i * 10
+	fonction lire() -> Ent {
+		revenir v
+	}
+	rappels = lire
+	si i == 1 {
+		casser
+	}
+}
+dernier := rappels()
+
+tours := 0
+pendant vrai { tours++; si tours == 3 { casser } }
+pendant i := 0; i < 5; i++ { si i < 4 { continuer } tours = tours + i }
+`
+	expectGlobals(t, src, map[string]interface{}{
+		"somme":   int64(56),
+		"paires":  int64(10),
+		"lignes":  int64(6),
+		"dernier": int64(10),
+		"tours":   int64(7),
+	})
+}
+
+// TestLongLoops checks loops that start past the first 64 KiB of code, and
+// a loop body too long for the operand of OP_LOOP.
+func TestLongLoops(t *testing.T) {
+	padding := "x := 0\n" + strings.Repeat("x++\n", 10000)
+	src := padding + "n := 0\npendant i := 0; i < 3; i++ {\n\tsi i == 1 {\n\t\tcontinuer\n\t}\n\tn++\n}\n"
+	expectGlobals(t, src, map[string]interface{}{"x": int64(10000), "n": int64(2)})
+
+	_, diagnostics := Compile([]byte("x := 0\npendant {\n" + strings.Repeat("\tx++\n", 10000) + "\tcasser\n}\n"))
+	if len(diagnostics) != 1 || diagnostics[0].Message != "Loop body too large." {
+		t.Errorf("got diagnostics %v", diagnostics)
+	}
+}
+
+func TestBreakOutsideLoop(t *testing.T) {
+	for _, src := range []string{
+		"casser\n",
+		"continuer\n",
+		"pendant {\n\tfonction f() {\n\t\tcasser\n\t}\n}\n",
+	} {
+		if !hasSyntaxError(src) {
+			t.Errorf("%q: expected a compile error", src)
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	src := "var pr\u00e9nom: Cha\u00eene = \"Zo\u00e9\"\n" +
+		"fonction d\u00e9but(x: Ent) -> Ent {\n\trevenir x + 1\n}\n" +
+		"var e\u0301te\u0301 = de\u0301but(1)\n" +
+		"var copie = pre\u0301nom\n"
+	expectGlobals(t, src, map[string]interface{}{
+		"été":   int64(2),
+		"copie": "Zoé",
+	})
+}
+
+func TestIndependentVMs(t *testing.T) {
+	var wg sync.WaitGroup
+	vms := make([]*VM, 8)
+	for i := range vms {
+		vms[i] = NewVM(Options{})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src := fmt.Sprintf("var x = 0\npendant i := 0; i < 1000; i++ {\n\tx = x + %d\n}\n", i)
+			if result, _ := vms[i].Interpret(context.Background(), []byte(src)); result != InterpretOk {
+				t.Errorf("vm %d: got result %d", i, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, vm := range vms {
+		if got := globalValue(t, vm, "x"); got.Integer() != int64(1000*i) {
+			t.Errorf("vm %d: x = %v", i, got.Value)
+		}
+	}
+}
+
+func TestVMKeepsGlobals(t *testing.T) {
+	var out bytes.Buffer
+	vm := NewVM(Options{Stdout: &out})
+	ctx := context.Background()
+
+	if result, _ := vm.Interpret(ctx, []byte("var nom = \"GNBS\"\n")); result != InterpretOk {
+		t.Fatalf("got result %d", result)
+	}
+	if result, _ := vm.Interpret(ctx, []byte("afficher nom\nafficher 1 + 2\n")); result != InterpretOk {
+		t.Fatalf("got result %d", result)
+	}
+	if out.String() != "GNBS\n3\n" {
+		t.Errorf("printed %q", out.String())
+	}
+}
+
+func TestInterpretCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	vm := NewVM(Options{})
+	result, err := vm.Interpret(ctx, []byte("pendant {\n}\n"))
+	if result != InterpretRuntimeError {
+		t.Fatalf("got result %d, want a runtime error", result)
+	}
+	if runtimeErr, ok := err.(*RuntimeError); !ok || runtimeErr.Kind != KindInterrupted {
+		t.Errorf("got error %v, want an interruption", err)
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		kind    ErrorKind
+		message string
+		frames  []StackFrame
+	}{
+		{
+			src:     "var x = 1\nx = x / 0\n",
+			kind:    KindDivisionByZero,
+			message: "Division by zero.",
+			frames:  []StackFrame{{"script", token.Position{Filename: "test.gnbs", Line: 2, Column: 9}}},
+		},
+		{
+			src: `fonction diviser(a: Ent, b: Ent) -> Ent {
+	revenir a / b
+}
+fonction moyenne(n: Ent) -> Ent {
+	revenir diviser(10, n)
+}
+afficher moyenne(0)
+`,
+			kind:    KindDivisionByZero,
+			message: "Division by zero.",
+			frames: []StackFrame{
+				{"diviser()", token.Position{Filename: "test.gnbs", Line: 2, Column: 14}},
+				{"moyenne()", token.Position{Filename: "test.gnbs", Line: 5, Column: 23}},
+				{"script", token.Position{Filename: "test.gnbs", Line: 7, Column: 19}},
+			},
+		},
+		{
+			src:     "fonction f() {\n\tafficher y\n}\nf()\n",
+			kind:    KindName,
+			message: "Undefined variable 'y'.",
+		},
+		{
+			src:     "fonction f() {\n\tf()\n}\nf()\n",
+			kind:    KindStackOverflow,
+			message: "Stack overflow.",
+		},
+		{
+			src:     "var f = nul\nf()\n",
+			kind:    KindType,
+			message: "Can only call functions and classes.",
+		},
+	}
+
+	for _, test := range tests {
+		vm := NewVM(Options{Filename: "test.gnbs"})
+		_, err := vm.Interpret(context.Background(), []byte(test.src))
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("%q: got error %v, want a *RuntimeError", test.src, err)
+			continue
+		}
+		if runtimeErr.Kind != test.kind || runtimeErr.Message != test.message {
+			t.Errorf("%q: got %s %q, want %s %q", test.src, runtimeErr.Kind, runtimeErr.Message, test.kind, test.message)
+		}
+		for i := range runtimeErr.Frames {
+			runtimeErr.Frames[i].Pos.Offset = 0
+		}
+		if test.frames != nil && !reflect.DeepEqual(runtimeErr.Frames, test.frames) {
+			t.Errorf("%q: got frames %v, want %v", test.src, runtimeErr.Frames, test.frames)
+		}
+		if !strings.HasPrefix(runtimeErr.Error(), test.message+"\n[test.gnbs:") {
+			t.Errorf("%q: got error text %q", test.src, runtimeErr.Error())
+		}
+	}
+}
+
+func TestCompileErrorResult(t *testing.T) {
+	vm := NewVM(Options{})
+	result, err := vm.Interpret(context.Background(), []byte("var = 1\n"))
+	if diagnostics, ok := err.(Diagnostics); result != InterpretCompileError || !ok || len(diagnostics) != 1 {
+		t.Errorf("got %d, %v, want a compile error", result, err)
+	}
+}
+
+func TestRunBytecode(t *testing.T) {
+	sources := []string{
+		"afficher 1 + 2\nafficher \"é\" + \"t\"\nafficher 2.5 ** 2.0\nafficher vrai et np faux\nafficher nul\n",
+		`fonction compteur() -> fonction {
+	var n = 0
+	fonction suivant() -> Ent {
+		n = n + 1
+		revenir n
+	}
+	revenir suivant
+}
+var c = compteur()
+c()
+afficher c()
+`,
+		`classe Animal {
+	nom: Cha
+	init(nom: Cha) {
+		ceci.nom = nom
+	}
+	parler() -> Cha {
+		revenir ceci.nom
+	}
+}
+classe Chien < Animal {
+	parler() -> Cha {
+		revenir super.parler() + " : ouaf"
+	}
+}
+fonction accueillir(a: Animal) -> Cha {
+	revenir a.parler()
+}
+afficher accueillir(Chien("Rex"))
+pendant i := 0; i < 3; i++ {
+	si i == 1 {
+		continuer
+	}
+	afficher i
+}
+`,
+	}
+
+	for _, src := range sources {
+		fn, diagnostics := New(Options{Filename: "prog.gnbs"}).Compile([]byte(src))
+		if fn == nil {
+			t.Fatalf("%q: %v", src, diagnostics)
+		}
+		var file bytes.Buffer
+		if err := chunk.WriteBytecode(&file, fn); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		if !chunk.IsBytecode(file.Bytes()) {
+			t.Errorf("%q: the file doesn't start with the magic", src)
+		}
+		loaded, err := chunk.ReadBytecode(&file)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		if !reflect.DeepEqual(loaded.Chunk.Code, fn.Chunk.Code) || !reflect.DeepEqual(loaded.Chunk.Positions, fn.Chunk.Positions) {
+			t.Errorf("%q: the code or positions changed", src)
+		}
+
+		var want, got bytes.Buffer
+		NewVM(Options{Stdout: &want}).Interpret(context.Background(), []byte(src))
+		if result, err := NewVM(Options{Stdout: &got}).Run(context.Background(), loaded); result != InterpretOk {
+			t.Errorf("%q: got result %d, %v", src, result, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%q: printed %q, want %q", src, got.String(), want.String())
+		}
+	}
+}
+
+func TestReadBytecodeErrors(t *testing.T) {
+	fn, _ := Compile([]byte("fonction f(a: Ent) -> Ent {\n\trevenir a\n}\nafficher f(1)\n"))
+	var file bytes.Buffer
+	if err := chunk.WriteBytecode(&file, fn); err != nil {
+		t.Fatal(err)
+	}
+	good := file.Bytes()
+
+	corrupt := func(i int, b byte) []byte {
+		data := append([]byte(nil), good...)
+		data[i] = b
+		return data
+	}
+	tests := map[string][]byte{
+		"not a GNBS bytecode file": []byte("afficher 1\n"),
+		"truncated bytecode file":  good[:6],
+		fmt.Sprintf("unsupported bytecode version %d", chunk.BytecodeVersion+1): corrupt(4, chunk.BytecodeVersion+1),
+		"checksum mismatch":      corrupt(len(good)-1, good[len(good)-1]+1),
+		"unexpected end of data": good[:len(good)-1],
+	}
+	for want, data := range tests {
+		if want == "unexpected end of data" {
+			// Keep the checksum right so that the payload itself is read.
+			data = append([]byte(nil), data...)
+			binary.LittleEndian.PutUint32(data[6:], crc32.ChecksumIEEE(data[10:]))
+		}
+		if _, err := chunk.ReadBytecode(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	}
+
+	// The types the VM checks values against must be complete.
+	for want, params := range map[string][]*chunk.Type{
+		"missing parameter type":     {nil},
+		"class type without a class": {{Kind: chunk.TypeInstance}},
+	} {
+		inner := assemble([]byte{OpNull, OpReturn})
+		inner.Arity = len(params)
+		inner.ParamTypes = params
+		file.Reset()
+		if err := chunk.WriteBytecode(&file, assemble([]byte{OpNull, OpReturn}, chunk.Value{Type: chunk.TypeFunction, Value: inner})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := chunk.ReadBytecode(&file); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	}
+}
+
+func TestManyConstants(t *testing.T) {
+	// Each declaration adds two constants, its name and its value, so the
+	// code after the first 128 uses the long instructions.
+	var src strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&src, "var v%d = %d\n", i, 1000+i)
+	}
+	src.WriteString(`v0 = v299 + 1
+classe A {
+	x: Ent
+	valeur() -> Ent {
+		revenir ceci.x
+	}
+}
+classe B < A {
+	valeur() -> Ent {
+		revenir super.valeur() * 2
+	}
+}
+fonction ajouter(n: Ent) -> fonction {
+	fonction f(m: Ent) -> Ent {
+		revenir n + m
+	}
+	revenir f
+}
+b := B()
+b.x = v1
+afficher v0
+afficher b.valeur()
+afficher ajouter(v2)(v3)
+afficher "fin"
+`)
+
+	fn, diagnostics := Compile([]byte(src.String()))
+	if fn == nil {
+		t.Fatal(diagnostics)
+	}
+	if len(fn.Chunk.Values) <= 256 {
+		t.Fatalf("only %d constants", len(fn.Chunk.Values))
+	}
+	if err := Verify(fn); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	vm := NewVM(Options{Stdout: &out})
+	if result, err := vm.Run(context.Background(), fn); result != InterpretOk {
+		t.Fatalf("got %d, %v", result, err)
+	}
+	if want := "1300\n2002\n2005\nfin\n"; out.String() != want {
+		t.Errorf("printed %q, want %q", out.String(), want)
+	}
+	if v150 := globalValue(t, vm, "v150"); v150.Integer() != 1150 {
+		t.Errorf("v150 = %v, want 1150", v150.Value)
+	}
+}
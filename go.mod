@@ -2,4 +2,7 @@ module GNBS
 
 go 1.15
 
-require github.com/spf13/cobra v1.1.1
+require (
+	github.com/spf13/cobra v1.1.1
+	golang.org/x/text v0.13.0
+)
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"go/token"
+	"golang.org/x/text/unicode/norm"
 	"path/filepath"
 	"strconv"
 	"unicode"
@@ -17,9 +18,10 @@ type Scanner struct {
 	file    *token.File
 	fileset *token.FileSet
 
-	dir string
-	src []byte
-	err ErrorHandler
+	dir    string
+	src    []byte
+	err    ErrorHandler
+	locale *token2.Locale
 
 	ch         rune
 	offset     int
@@ -28,19 +30,34 @@ type Scanner struct {
 
 	insertSemi bool
 
+	pragmas []pragma
+
 	ErrorCount int
 }
 
+// pragma is the locale name given by a //gnbs:locale comment.
+type pragma struct {
+	offset int
+	name   string
+}
+
 type Token struct {
 	Position token.Pos
-	Token    token2.TokenType
-	LitName  string
+	// End is the position just after the token.
+	End     token.Pos
+	Token   token2.TokenType
+	LitName string
 }
 
 func NewScanner(src []byte, err ErrorHandler) *Scanner {
+	return NewFileScanner("", src, err)
+}
 
+// NewFileScanner is NewScanner for the source of the file filename, which
+// is reported in the positions of the tokens.
+func NewFileScanner(filename string, src []byte, err ErrorHandler) *Scanner {
 	fset := token.NewFileSet()
-	file := fset.AddFile("", fset.Base(), len(src))
+	file := fset.AddFile(filename, fset.Base(), len(src))
 
 	if file.Size() != len(src) {
 		panic(fmt.Sprintf("file size (%d) does not match src len (%d)", file.Size(), len(src)))
@@ -53,6 +70,7 @@ func NewScanner(src []byte, err ErrorHandler) *Scanner {
 		dir:        dir,
 		src:        src,
 		err:        err,
+		locale:     token2.French,
 		ch:         ' ',
 		offset:     0,
 		rdOffset:   0,
@@ -64,7 +82,13 @@ func NewScanner(src []byte, err ErrorHandler) *Scanner {
 	return scanner
 }
 
-func (s *Scanner) Scan() (token *Token) {
+func (s *Scanner) Scan() *Token {
+	token := s.scan()
+	token.End = s.file.Pos(s.offset)
+	return token
+}
+
+func (s *Scanner) scan() (token *Token) {
 	token = &Token{}
 
 scanAgain:
@@ -77,12 +101,22 @@ scanAgain:
 	switch ch := s.ch; {
 
 	case isLetter(ch):
+		offs := s.offset
 		token.LitName = s.scanIdentifier()
 		if len(token.LitName) > 1 {
-			token.Token = token2.Lookup(token.LitName)
+			token.Token = s.locale.Lookup(token.LitName)
+			for _, word := range s.locale.Compounds(token.LitName) {
+				if s.scanWord(word) {
+					token.LitName = norm.NFC.String(string(s.src[offs:s.offset]))
+					token.Token = s.locale.Lookup(token.LitName)
+					break
+				}
+			}
 
 			switch token.Token {
-			case token2.Identifier, token2.Return, token2.Break:
+			case token2.Identifier, token2.Return, token2.Break, token2.Continue,
+				token2.True, token2.False, token2.Null, token2.This, token2.Super,
+				token2.IntType, token2.FloatType, token2.StringType, token2.BoolType:
 				insertSemi = true
 			}
 		} else {
@@ -118,6 +152,8 @@ scanAgain:
 			token.Token = token2.Dot
 		case ',':
 			token.Token = token2.Comma
+		case ':':
+			token.Token = s.switch2(token2.Colon, token2.Define)
 		case ';':
 			token.Token = token2.Semicolon
 			token.LitName = ";"
@@ -138,10 +174,28 @@ scanAgain:
 			token.Token = token2.RBrace
 		case '+':
 			token.Token = token2.Plus
+			if s.ch == '+' {
+				s.next()
+				insertSemi = true
+				token.Token = token2.Increment
+			}
 		case '-':
 			token.Token = token2.Minus
+			switch s.ch {
+			case '>':
+				s.next()
+				token.Token = token2.Arrow
+			case '-':
+				s.next()
+				insertSemi = true
+				token.Token = token2.Decrement
+			}
 		case '*':
 			token.Token = token2.Star
+			if s.ch == '*' {
+				s.next()
+				token.Token = token2.Power
+			}
 		case '/':
 			if s.ch == '/' || s.ch == '*' {
 				if s.insertSemi && s.findLineEnd() {
@@ -159,6 +213,8 @@ scanAgain:
 			}
 		case '<':
 			token.Token = s.switch2(token2.Less, token2.LessEqual)
+		case '>':
+			token.Token = s.switch2(token2.Greater, token2.GreaterEqual)
 		case '=':
 			token.Token = s.switch2(token2.Equal, token2.EqualEqual)
 		case '!':
@@ -176,12 +232,28 @@ scanAgain:
 
 // Scan Functions
 
+// scanIdentifier returns the identifier in Unicode normal form C, so that
+// names typed with composed and decomposed accents are the same.
 func (s *Scanner) scanIdentifier() string {
 	offs := s.offset
-	for isLetter(s.ch) || isDigit(s.ch) {
+	for isLetter(s.ch) || isDigit(s.ch) || isMark(s.ch) {
 		s.next()
 	}
-	return string(s.src[offs:s.offset])
+	return norm.NFC.String(string(s.src[offs:s.offset]))
+}
+
+// scanWord consumes the blanks and the identifier that follow on the same
+// line if that identifier is word; otherwise it leaves the scanner untouched.
+func (s *Scanner) scanWord(word string) bool {
+	ch, offset, rdOffset := s.ch, s.offset, s.rdOffset
+	for s.ch == ' ' || s.ch == '\t' {
+		s.next()
+	}
+	if s.offset > offset && isLetter(s.ch) && s.scanIdentifier() == word {
+		return true
+	}
+	s.ch, s.offset, s.rdOffset = ch, offset, rdOffset
+	return false
 }
 
 func (s *Scanner) scanComments() string {
@@ -214,10 +286,12 @@ func (s *Scanner) scanComments() string {
 			if ch == '*' && s.ch == '/' {
 				s.next()
 				next = s.offset
-			} else {
-				s.error(offs, "comment not terminated")
+				break
 			}
 		}
+		if next < 0 {
+			s.error(offs, "comment not terminated")
+		}
 	}
 
 	lit := s.src[offs:s.offset]
@@ -229,6 +303,9 @@ func (s *Scanner) scanComments() string {
 	if next >= 0 && (lit[1] == '*' || offs == s.lineOffset) && bytes.HasPrefix(lit[2:], prefix) {
 		s.updateLineInfo(next, offs, lit)
 	}
+	if lit[1] == '/' && bytes.HasPrefix(lit[2:], localePrefix) {
+		s.updateLocale(offs, lit)
+	}
 	return string(lit)
 }
 
@@ -379,7 +456,7 @@ func (s *Scanner) next() {
 		case r == 0:
 			s.error(s.offset, "illegal character NUL")
 		case r >= utf8.RuneSelf:
-			r, w = utf8.DecodeLastRune(s.src[s.rdOffset:])
+			r, w = utf8.DecodeRune(s.src[s.rdOffset:])
 			if r == utf8.RuneError && w == 1 {
 				s.error(s.offset, "illegal utf8 encoding")
 			}
@@ -414,7 +491,7 @@ func (s *Scanner) errorf(offs int, format string, args ...interface{}) {
 }
 
 func (s *Scanner) skipWhitespace() {
-	for s.ch == ' ' || s.ch == '\t' || s.ch == '\r' {
+	for s.ch == ' ' || s.ch == '\t' || s.ch == '\n' && !s.insertSemi || s.ch == '\r' {
 		s.next()
 	}
 }
@@ -506,6 +583,25 @@ func (s *Scanner) findLineEnd() bool {
 }
 
 var prefix = []byte("line ")
+var localePrefix = []byte("gnbs:locale ")
+
+// updateLocale switches the reserved words used by the rest of the file to
+// the locale named by a //gnbs:locale pragma.
+func (s *Scanner) updateLocale(offs int, text []byte) {
+	text = text[2+len(localePrefix):]
+	offs += 2 + len(localePrefix)
+
+	name := string(bytes.TrimSpace(text))
+	offs += bytes.Index(text, []byte(name))
+
+	locale, ok := token2.GetLocale(name)
+	if !ok {
+		s.error(offs, "unknown locale: "+name)
+		return
+	}
+	s.locale = locale
+	s.pragmas = append(s.pragmas, pragma{offs, name})
+}
 
 func (s *Scanner) digits(base int, invalid *int) (digsep int) {
 	if base <= 10 {
@@ -540,6 +636,24 @@ func (s *Scanner) switch2(tok0, tok1 token2.TokenType) token2.TokenType {
 	return tok0
 }
 
+// SetLocale selects the spelling of the reserved words. A //gnbs:locale
+// pragma in the source takes precedence from the line it appears on.
+func (s *Scanner) SetLocale(locale *token2.Locale) {
+	s.locale = locale
+}
+
+func (s *Scanner) Locale() *token2.Locale {
+	return s.locale
+}
+
+// Peek returns the token that the next call to Scan will return, without
+// consuming it. Errors are reported when the token is actually scanned.
+func (s *Scanner) Peek() *Token {
+	lookahead := *s
+	lookahead.err = nil
+	return lookahead.Scan()
+}
+
 func (s *Scanner) GetPosition(pos token.Pos) *token2.Position {
 	position := s.fileset.Position(pos)
 	return &token2.Position{
@@ -563,6 +677,13 @@ func trailingDigits(text []byte) (int, int, bool) {
 func isLetter(ch rune) bool {
 	return 'a' <= lower(ch) && lower(ch) <= 'z' || ch == '_' || ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
+
+// isMark reports whether ch is a combining mark, such as the accent of a
+// decomposed letter.
+func isMark(ch rune) bool {
+	return ch >= utf8.RuneSelf && unicode.In(ch, unicode.Mn, unicode.Mc)
+}
+
 func isDigit(ch rune) bool {
 	return isDecimal(ch) || ch >= utf8.RuneSelf && unicode.IsDigit(ch)
 }
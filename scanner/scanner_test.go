@@ -2,6 +2,7 @@ package scanner
 
 import (
 	token2 "GNBS/token"
+	"go/token"
 	"testing"
 )
 
@@ -21,3 +22,160 @@ func TestNewScanner(t *testing.T) {
 		}
 	}
 }
+
+func TestKeywords(t *testing.T) {
+	tests := []struct {
+		src string
+		tok token2.TokenType
+	}{
+		{"Ent", token2.IntType},
+		{"Entier", token2.IntType},
+		{"Flot", token2.FloatType},
+		{"Flottant", token2.FloatType},
+		{"Cha", token2.StringType},
+		{"Chaîne", token2.StringType},
+		{"si", token2.If},
+		{"sinon si", token2.ElseIf},
+		{"sinon \tsi", token2.ElseIf},
+		{"autre", token2.Else},
+		{"et", token2.And},
+		{"ou", token2.Or},
+		{"np", token2.Not},
+		{"mod", token2.Mod},
+		{"div", token2.Div},
+		{"var", token2.Var},
+		{"fonction", token2.Function},
+		{"classe", token2.Class},
+		{"revenir", token2.Return},
+		{"pendant", token2.For},
+		{"sinon", token2.Identifier},
+		{"sinonsi", token2.Identifier},
+		{"function", token2.Identifier},
+	}
+
+	for _, test := range tests {
+		s := NewScanner([]byte(test.src), nil)
+		tk := s.Scan()
+		if tk.Token != test.tok {
+			t.Errorf("%q: got %s, want %s", test.src, tk.Token, test.tok)
+		}
+		if tk.LitName != test.src {
+			t.Errorf("%q: got literal %q", test.src, tk.LitName)
+		}
+		if tk = s.Scan(); tk.Token != token2.Semicolon && tk.Token != token2.Eof {
+			t.Errorf("%q: unexpected trailing token %s %q", test.src, tk.Token, tk.LitName)
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	tests := []struct {
+		src  string
+		tok  token2.TokenType
+		name string
+	}{
+		{"début", token2.Identifier, "début"},
+		{"pr\u00e9nom", token2.Identifier, "prénom"},
+		{"pre\u0301nom", token2.Identifier, "prénom"},
+		{"e\u0301te\u0301", token2.Identifier, "été"},
+		{"garc\u0327on2", token2.Identifier, "garçon2"},
+		{"Ça", token2.Identifier, "Ça"},
+		{"Noël", token2.Identifier, "Noël"},
+		{"ĳssel", token2.Identifier, "ĳssel"},
+		{"数", token2.Identifier, "数"},
+		{"π", token2.Identifier, "π"},
+		{"Chai\u0302ne", token2.StringType, "Chaîne"},
+		{"Boole\u0301en", token2.BoolType, "Booléen"},
+	}
+
+	for _, test := range tests {
+		s := NewScanner([]byte(test.src), nil)
+		tk := s.Scan()
+		if tk.Token != test.tok || tk.LitName != test.name {
+			t.Errorf("%q: got %s %q, want %s %q", test.src, tk.Token, tk.LitName, test.tok, test.name)
+		}
+		if end := s.GetPosition(tk.End).Offset; end != len(test.src) {
+			t.Errorf("%q: token ends at %d, want %d", test.src, end, len(test.src))
+		}
+		if tk = s.Scan(); tk.Token != token2.Semicolon && tk.Token != token2.Eof {
+			t.Errorf("%q: unexpected trailing token %s %q", test.src, tk.Token, tk.LitName)
+		}
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	var errs []string
+	s := NewScanner([]byte("a\xffb"), func(pos token.Position, msg string) {
+		errs = append(errs, msg)
+	})
+	for tk := s.Scan(); tk.Token != token2.Eof; tk = s.Scan() {
+	}
+	if len(errs) != 1 || errs[0] != "illegal utf8 encoding" {
+		t.Errorf("got errors %q", errs)
+	}
+}
+
+func TestPowerOperator(t *testing.T) {
+	s := NewScanner([]byte("a ** b * c mod d div e"), nil)
+	want := []token2.TokenType{
+		token2.Identifier, token2.Power, token2.Identifier, token2.Star, token2.Identifier,
+		token2.Mod, token2.Identifier, token2.Div, token2.Identifier, token2.Semicolon, token2.Eof,
+	}
+	for i, tok := range want {
+		if tk := s.Scan(); tk.Token != tok {
+			t.Fatalf("token %d: got %s, want %s", i, tk.Token, tok)
+		}
+	}
+}
+
+func TestElseIfChain(t *testing.T) {
+	src := []byte(`si x { } sinon si y { } autre { }`)
+	want := []token2.TokenType{
+		token2.If, token2.Identifier, token2.LBrace, token2.RBrace,
+		token2.ElseIf, token2.Identifier, token2.LBrace, token2.RBrace,
+		token2.Else, token2.LBrace, token2.RBrace, token2.Semicolon, token2.Eof,
+	}
+
+	s := NewScanner(src, nil)
+	for i, tok := range want {
+		if tk := s.Scan(); tk.Token != tok {
+			t.Fatalf("token %d: got %s %q, want %s", i, tk.Token, tk.LitName, tok)
+		}
+	}
+}
+
+func TestDeclarationTokens(t *testing.T) {
+	src := []byte("var x: Ent\ny := 10\nfonction f() -> Ent {}")
+	want := []token2.TokenType{
+		token2.Var, token2.Identifier, token2.Colon, token2.IntType, token2.Semicolon,
+		token2.Identifier, token2.Define, token2.Integer, token2.Semicolon,
+		token2.Function, token2.Identifier, token2.LParentheses, token2.RParentheses,
+		token2.Arrow, token2.IntType, token2.LBrace, token2.RBrace, token2.Semicolon,
+		token2.Eof,
+	}
+
+	s := NewScanner(src, nil)
+	for i, tok := range want {
+		if tk := s.Scan(); tk.Token != tok {
+			t.Fatalf("token %d: got %s %q, want %s", i, tk.Token, tk.LitName, tok)
+		}
+	}
+}
+
+func TestIncDecTokens(t *testing.T) {
+	src := []byte("pendant i := 0; i < 10; i++ {\n\tj--\n}")
+	want := []token2.TokenType{
+		token2.For, token2.Identifier, token2.Define, token2.Integer, token2.Semicolon,
+		token2.Identifier, token2.Less, token2.Integer, token2.Semicolon,
+		token2.Identifier, token2.Increment, token2.LBrace,
+		token2.Identifier, token2.Decrement, token2.Semicolon,
+		token2.RBrace, token2.Semicolon, token2.Eof,
+	}
+
+	s := NewScanner(src, nil)
+	for i, tok := range want {
+		if tk := s.Scan(); tk.Token != tok {
+			t.Fatalf("token %d: got %s %q, want %s", i, tk.Token, tk.LitName, tok)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package scanner
+
+import (
+	token2 "GNBS/token"
+	"bytes"
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type edit struct {
+	offset int
+	length int
+	text   string
+}
+
+// Translate rewrites src, whose reserved words are spelled as in from, with
+// the spellings of to. Reserved words and the names given by //gnbs:locale
+// pragmas are the only bytes that change; comments, literals and layout
+// are copied as they are.
+func Translate(src []byte, from, to *token2.Locale) ([]byte, error) {
+	var err error
+	s := NewScanner(src, func(pos token.Position, msg string) {
+		if err == nil {
+			err = fmt.Errorf("%d:%d: %s", pos.Line, pos.Column, msg)
+		}
+	})
+	s.SetLocale(from)
+
+	var edits []edit
+	for tk := s.Scan(); tk.Token != token2.Eof && err == nil; tk = s.Scan() {
+		if r, _ := utf8.DecodeRuneInString(tk.LitName); !isLetter(r) {
+			continue
+		}
+
+		pos := s.GetPosition(tk.Position)
+		if tk.Token == token2.Identifier {
+			if to.Lookup(tk.LitName) != token2.Identifier {
+				err = fmt.Errorf("%d:%d: %q is a reserved word in locale %q", pos.Line, pos.Column, tk.LitName, to.Name)
+			}
+			continue
+		}
+
+		canonical, ok := s.Locale().Canonical(tk.LitName)
+		if !ok {
+			continue
+		}
+		spelling, ok := to.Spelling(canonical)
+		if !ok {
+			err = fmt.Errorf("%d:%d: locale %q has no spelling for %q", pos.Line, pos.Column, to.Name, tk.LitName)
+			continue
+		}
+		length := s.GetPosition(tk.End).Offset - pos.Offset
+		edits = append(edits, edit{pos.Offset, length, respace(spelling, tk.LitName)})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range s.pragmas {
+		edits = append(edits, edit{p.offset, len(p.name), to.Name})
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].offset < edits[j].offset })
+
+	var out bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		out.Write(src[last:e.offset])
+		out.WriteString(e.text)
+		last = e.offset + e.length
+	}
+	out.Write(src[last:])
+	return out.Bytes(), nil
+}
+
+// respace keeps the blanks found between the two words of a compound
+// reserved word such as "sinon  si".
+func respace(spelling, word string) string {
+	parts := strings.Fields(spelling)
+	i, j := strings.IndexAny(word, " \t"), strings.LastIndexAny(word, " \t")
+	if len(parts) != 2 || i < 0 {
+		return spelling
+	}
+	return parts[0] + word[i:j+1] + parts[1]
+}
@@ -0,0 +1,90 @@
+package scanner
+
+import (
+	token2 "GNBS/token"
+	"testing"
+)
+
+const frenchSource = `// calcule la somme
+fonction somme(a, b) {
+	revenir a + b /* et rien d'autre */
+}
+
+si vrai et np faux {
+	afficher "si et ou"
+} sinon  si x {
+	var y = nul
+} autre {
+	casser
+}
+
+pendant {
+	continuer
+}
+`
+
+const englishSource = `// calcule la somme
+function somme(a, b) {
+	return a + b /* et rien d'autre */
+}
+
+if true and not false {
+	print "si et ou"
+} else  if x {
+	var y = null
+} else {
+	break
+}
+
+for {
+	continue
+}
+`
+
+func TestTranslate(t *testing.T) {
+	en, err := Translate([]byte(frenchSource), token2.French, token2.English)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(en) != englishSource {
+		t.Errorf("fr -> en:\n%s", en)
+	}
+
+	fr, err := Translate(en, token2.English, token2.French)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fr) != frenchSource {
+		t.Errorf("en -> fr:\n%s", fr)
+	}
+}
+
+func TestTranslatePragma(t *testing.T) {
+	src := "//gnbs:locale en\nvar x = true\n"
+
+	fr, err := Translate([]byte(src), token2.French, token2.French)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "//gnbs:locale fr\nvar x = vrai\n"; string(fr) != want {
+		t.Errorf("got %q, want %q", fr, want)
+	}
+}
+
+func TestTranslateReservedIdentifier(t *testing.T) {
+	if _, err := Translate([]byte("var class = 1\n"), token2.French, token2.English); err == nil {
+		t.Error("expected an error for an identifier reserved in the target locale")
+	}
+}
+
+func TestTranslateDecomposed(t *testing.T) {
+	src := "var nom: Chai\u0302ne = \"e\u0301\"\n"
+
+	en, err := Translate([]byte(src), token2.French, token2.English)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "var nom: String = \"e\u0301\"\n"; string(en) != want {
+		t.Errorf("got %q, want %q", en, want)
+	}
+}
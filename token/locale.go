@@ -0,0 +1,118 @@
+package token
+
+import "strings"
+
+// Locale is a set of spellings for the reserved words. Every spelling is
+// mapped to the canonical French one, which is what the token table holds,
+// so a locale never needs tokens of its own.
+type Locale struct {
+	Name string
+
+	canonical map[string]string
+	spelling  map[string]string
+	compounds map[string][]string
+}
+
+// French is the canonical locale, the one used when nothing else is asked.
+var French = NewLocale("fr", nil)
+
+// English is the alias set for English speaking teams.
+var English = NewLocale("en", map[string]string{
+	"and":      "et",
+	"class":    "classe",
+	"else":     "autre",
+	"false":    "faux",
+	"for":      "pendant",
+	"function": "fonction",
+	"if":       "si",
+	"null":     "nul",
+	"or":       "ou",
+	"print":    "afficher",
+	"return":   "revenir",
+	"super":    "super",
+	"this":     "ceci",
+	"true":     "vrai",
+	"var":      "var",
+	"break":    "casser",
+	"continue": "continuer",
+	"else if":  "sinon si",
+	"mod":      "mod",
+	"div":      "div",
+	"not":      "np",
+	"Int":      "Ent",
+	"Integer":  "Entier",
+	"Flt":      "Flot",
+	"Float":    "Flottant",
+	"Str":      "Cha",
+	"String":   "Chaîne",
+	"Bool":     "Bool",
+	"Boolean":  "Booléen",
+})
+
+var locales = map[string]*Locale{
+	French.Name:  French,
+	English.Name: English,
+}
+
+// NewLocale builds a locale from a map of its spellings to the canonical
+// ones. A nil map gives the canonical locale itself.
+func NewLocale(name string, words map[string]string) *Locale {
+	if words == nil {
+		words = make(map[string]string)
+		for word := range keywords {
+			words[word] = word
+		}
+	}
+
+	l := &Locale{
+		Name:      name,
+		canonical: make(map[string]string),
+		spelling:  make(map[string]string),
+		compounds: make(map[string][]string),
+	}
+	for word, canonical := range words {
+		l.canonical[word] = canonical
+		l.spelling[canonical] = word
+		if parts := strings.Fields(word); len(parts) == 2 {
+			l.compounds[parts[0]] = append(l.compounds[parts[0]], parts[1])
+		}
+	}
+	return l
+}
+
+// RegisterLocale makes l available to GetLocale and to the locale pragma.
+func RegisterLocale(l *Locale) {
+	locales[l.Name] = l
+}
+
+func GetLocale(name string) (*Locale, bool) {
+	l, ok := locales[name]
+	return l, ok
+}
+
+func (l *Locale) Lookup(ident string) TokenType {
+	if canonical, ok := l.Canonical(ident); ok {
+		return Lookup(canonical)
+	}
+	return Identifier
+}
+
+// Canonical returns the French spelling of a reserved word of l. Words
+// made of two parts may be separated by any run of blanks.
+func (l *Locale) Canonical(word string) (string, bool) {
+	canonical, ok := l.canonical[strings.Join(strings.Fields(word), " ")]
+	return canonical, ok
+}
+
+// Spelling returns how l writes the reserved word whose French spelling
+// is canonical.
+func (l *Locale) Spelling(canonical string) (string, bool) {
+	word, ok := l.spelling[canonical]
+	return word, ok
+}
+
+// Compounds returns the words that may follow first to form a two-word
+// reserved word, such as "si" after "sinon".
+func (l *Locale) Compounds(first string) []string {
+	return l.compounds[first]
+}
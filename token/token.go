@@ -16,6 +16,7 @@ const (
 	LBracket
 	RBracket
 	Comma
+	Colon
 	Dot
 	Minus
 	Plus
@@ -34,6 +35,11 @@ const (
 	EqualEqual
 	GreaterEqual
 	LessEqual
+	Arrow
+	Define
+	Increment
+	Decrement
+	Power
 
 	String
 	Integer
@@ -57,6 +63,14 @@ const (
 	True
 	Var
 	Break
+	Continue
+	ElseIf
+	Mod
+	Div
+	IntType
+	FloatType
+	StringType
+	BoolType
 	keywords_end
 
 	Illegal
@@ -72,6 +86,7 @@ var tokens = [...]string{
 	LBracket:     "[",
 	RBracket:     "]",
 	Comma:        ",",
+	Colon:        ":",
 	Dot:          ".",
 	Minus:        "-",
 	Plus:         "+",
@@ -88,29 +103,41 @@ var tokens = [...]string{
 	GreaterEqual: ">=",
 	NotEqual:     "!=",
 	EqualEqual:   "==",
-
+	Arrow:        "->",
+	Define:       ":=",
+	Increment:    "++",
+	Decrement:    "--",
+	Power:        "**",
 
 	String:     "STRING",
 	Integer:    "INTEGER",
 	Float:      "FLOAT",
 	Identifier: "IDENTIFIER",
 
-	And:      "AND",
-	Class:    "CLASS",
-	Else:     "ELSE",
-	False:    "FALSE",
-	For:      "FOR",
-	Function: "FUNCTION",
-	If:       "IF",
-	Null:     "NULL",
-	Or:       "OR",
-	Print:    "PRINT",
-	Return:   "RETURN",
-	Super:    "SUPER",
-	This:     "THIS",
-	True:     "TRUE",
-	Var:      "VAR",
-	Break:    "BREAK",
+	And:        "et",
+	Class:      "classe",
+	Else:       "autre",
+	False:      "faux",
+	For:        "pendant",
+	Function:   "fonction",
+	If:         "si",
+	Null:       "nul",
+	Or:         "ou",
+	Print:      "afficher",
+	Return:     "revenir",
+	Super:      "super",
+	This:       "ceci",
+	True:       "vrai",
+	Var:        "var",
+	Break:      "casser",
+	Continue:   "continuer",
+	ElseIf:     "sinon si",
+	Mod:        "mod",
+	Div:        "div",
+	IntType:    "Ent",
+	FloatType:  "Flot",
+	StringType: "Cha",
+	BoolType:   "Bool",
 
 	Eof: "EOF",
 }
@@ -126,13 +153,27 @@ func (t TokenType) String() string {
 	return s
 }
 
-var keywords map[string]TokenType
+var keywords = makeKeywords()
+
+// aliases are the reserved words that do not have a token of their own:
+// the long forms of the type names and the word form of '!'.
+var aliases = map[string]TokenType{
+	"np":       Not,
+	"Entier":   IntType,
+	"Flottant": FloatType,
+	"Chaîne":   StringType,
+	"Booléen":  BoolType,
+}
 
-func init() {
-	keywords = make(map[string]TokenType)
+func makeKeywords() map[string]TokenType {
+	keywords := make(map[string]TokenType)
 	for i := keywords_begin + 1; i < keywords_end; i++ {
 		keywords[tokens[i]] = i
 	}
+	for name, tok := range aliases {
+		keywords[name] = tok
+	}
+	return keywords
 }
 
 func Lookup(ident string) TokenType {
@@ -142,6 +183,11 @@ func Lookup(ident string) TokenType {
 	return Identifier
 }
 
+// IsType reports whether t names one of the basic types.
+func (t TokenType) IsType() bool {
+	return t == IntType || t == FloatType || t == StringType || t == BoolType
+}
+
 func IsKeyword(name string) bool {
 	_, ok := keywords[name]
 	return ok
@@ -0,0 +1,42 @@
+package token
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := map[string]TokenType{
+		"Ent":       IntType,
+		"Entier":    IntType,
+		"Flot":      FloatType,
+		"Flottant":  FloatType,
+		"Cha":       StringType,
+		"Chaîne":    StringType,
+		"Bool":      BoolType,
+		"Booléen":   BoolType,
+		"si":        If,
+		"sinon si":  ElseIf,
+		"autre":     Else,
+		"et":        And,
+		"ou":        Or,
+		"np":        Not,
+		"mod":       Mod,
+		"var":       Var,
+		"fonction":  Function,
+		"classe":    Class,
+		"revenir":   Return,
+		"pendant":   For,
+		"casser":    Break,
+		"continuer": Continue,
+		"AND":       Identifier,
+		"FUNCTION":  Identifier,
+		"nom":       Identifier,
+	}
+
+	for name, want := range tests {
+		if got := Lookup(name); got != want {
+			t.Errorf("Lookup(%q) = %s, want %s", name, got, want)
+		}
+		if IsKeyword(name) != (want != Identifier) {
+			t.Errorf("IsKeyword(%q) = %v", name, IsKeyword(name))
+		}
+	}
+}